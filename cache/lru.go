@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type lruItem struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// кэш с ограничением количества записей, при переполнении вытесняются давно не использованные,
+// просроченные записи удаляются при обращении
+type LRUCache struct {
+	mutex sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func NewLRUCache(size int) *LRUCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &LRUCache{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := e.Value.(*lruItem)
+	if !time.Now().Before(item.expiresAt) {
+		c.order.Remove(e)
+		delete(c.items, key)
+
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return item.value, true
+}
+
+func (c *LRUCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+
+	if e, ok := c.items[key]; ok {
+		item := e.Value.(*lruItem)
+		item.value = value
+		item.expiresAt = expiresAt
+		c.order.MoveToFront(e)
+
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*lruItem).key)
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+type MemoryCache struct {
+	mutex sync.RWMutex
+	items map[string]memoryItem
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		items: map[string]memoryItem{},
+	}
+}
+
+func (c *MemoryCache) Get(key string) (interface{}, bool) {
+	c.mutex.RLock()
+	item, ok := c.items[key]
+	c.mutex.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(item.expiresAt) {
+		c.mutex.Lock()
+		// запись могли обновить между блокировками
+		if current, ok := c.items[key]; ok && !time.Now().Before(current.expiresAt) {
+			delete(c.items, key)
+		}
+		c.mutex.Unlock()
+
+		return nil, false
+	}
+
+	return item.value, true
+}
+
+func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.items[key] = memoryItem{
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
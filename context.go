@@ -3,10 +3,15 @@ package workers
 import (
 	"context"
 	"fmt"
+	"sync"
 )
 
 var (
-	attemptContextKey = &contextKey{"attempt"}
+	attemptContextKey   = &contextKey{"attempt"}
+	cleanupContextKey   = &contextKey{"cleanup"}
+	heartbeatContextKey = &contextKey{"heartbeat"}
+	taskIdContextKey    = &contextKey{"task-id"}
+	workerIdContextKey  = &contextKey{"worker-id"}
 )
 
 type contextKey struct {
@@ -29,3 +34,91 @@ func AttemptFromContext(ctx context.Context) (int64, bool) {
 func NewContextWithAttempt(ctx context.Context, attempt int64) context.Context {
 	return context.WithValue(ctx, attemptContextKey, attempt)
 }
+
+func TaskIdFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(taskIdContextKey).(string)
+	return id, ok
+}
+
+func NewContextWithTaskId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIdContextKey, id)
+}
+
+func WorkerIdFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(workerIdContextKey).(string)
+	return id, ok
+}
+
+func NewContextWithWorkerId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, workerIdContextKey, id)
+}
+
+func NewContextWithHeartbeat(ctx context.Context, heartbeat func()) context.Context {
+	return context.WithValue(ctx, heartbeatContextKey, heartbeat)
+}
+
+// сообщает диспетчеру, что задача жива и продолжает выполнение
+func Heartbeat(ctx context.Context) bool {
+	heartbeat, ok := ctx.Value(heartbeatContextKey).(func())
+	if !ok {
+		return false
+	}
+
+	heartbeat()
+	return true
+}
+
+type contextCleanup struct {
+	mutex sync.Mutex
+	done  bool
+	funcs []func()
+}
+
+func NewContextWithCleanup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cleanupContextKey, &contextCleanup{})
+}
+
+// регистрирует функцию очистки, которую диспетчер вызовет один раз после завершения выполнения задачи
+// по любой причине, если очистка уже выполнена, функция вызывается сразу
+func OnCleanup(ctx context.Context, f func()) bool {
+	c, ok := ctx.Value(cleanupContextKey).(*contextCleanup)
+	if !ok {
+		return false
+	}
+
+	c.mutex.Lock()
+	if c.done {
+		c.mutex.Unlock()
+		f()
+
+		return true
+	}
+
+	c.funcs = append(c.funcs, f)
+	c.mutex.Unlock()
+
+	return true
+}
+
+// вызывает зарегистрированные функции очистки в обратном порядке, повторные вызовы ничего не делают
+func RunCleanup(ctx context.Context) {
+	c, ok := ctx.Value(cleanupContextKey).(*contextCleanup)
+	if !ok {
+		return
+	}
+
+	c.mutex.Lock()
+	if c.done {
+		c.mutex.Unlock()
+		return
+	}
+
+	c.done = true
+	funcs := c.funcs
+	c.funcs = nil
+	c.mutex.Unlock()
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
@@ -11,6 +11,8 @@ const (
 	DispatcherStatusWait
 	DispatcherStatusProcess
 	DispatcherStatusCancel
+	DispatcherStatusPause
+	DispatcherStatusShutdown
 )
 
 func (i DispatcherStatus) Int64() int64 {
@@ -38,7 +40,7 @@ type Dispatcher interface {
 	GetTaskMetadata(string) Metadata
 	GetTasks() []Task
 
-	AddListener(Event, Listener) error
+	AddListener(Event, Listener) (func(), error)
 	RemoveListener(Event, Listener)
 	GetListenerMetadata(string) Metadata
 	GetListeners() []Listener
@@ -0,0 +1,177 @@
+package dispatcher
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/manager"
+)
+
+const defaultAutoscaleInterval = time.Second
+
+type autoscaleHolder struct {
+	min     int
+	max     int
+	factory func() workers.Worker
+}
+
+// включает автоматическое изменение пула: периодически сравнивается число готовых к запуску задач
+// со свободными местами у воркеров, недостающие воркеры создаются factory, но не больше max, а при пустой
+// очереди лишние простаивающие воркеры выводятся из пула, но не меньше min. Выполняющиеся задачи
+// при этом не отменяются. Учитываются все воркеры пула, а не только созданные factory, nil отключает
+func (d *SimpleDispatcher) SetAutoscale(min, max int, factory func() workers.Worker) error {
+	if factory == nil {
+		d.autoscale.Store(autoscaleHolder{})
+		d.notifyAutoscaleChanged()
+		return nil
+	}
+
+	if min < 0 || max < 1 || min > max {
+		return errors.New("Autoscale bounds must satisfy 0 <= min <= max and max > 0")
+	}
+
+	d.autoscale.Store(autoscaleHolder{
+		min:     min,
+		max:     max,
+		factory: factory,
+	})
+	d.notifyAutoscaleChanged()
+
+	return nil
+}
+
+func (d *SimpleDispatcher) notifyAutoscaleChanged() {
+	select {
+	case d.autoscaleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// период проверки пула автомасштабированием, по умолчанию секунда
+func (d *SimpleDispatcher) SetAutoscaleInterval(interval time.Duration) {
+	atomic.StoreInt64(&d.autoscalePeriod, int64(interval))
+}
+
+func (d *SimpleDispatcher) AutoscaleInterval() time.Duration {
+	if interval := time.Duration(atomic.LoadInt64(&d.autoscalePeriod)); interval > 0 {
+		return interval
+	}
+
+	return defaultAutoscaleInterval
+}
+
+// таймер взводится только пока автомасштабирование включено, без него цикл лишь ждёт изменения настройки
+func (d *SimpleDispatcher) doAutoscale() {
+	defer d.wg.Done()
+
+	var timer clock.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		var tick <-chan time.Time
+
+		if h, _ := d.autoscale.Load().(autoscaleHolder); h.factory != nil {
+			if timer == nil {
+				timer = d.clock.NewTimer(d.AutoscaleInterval())
+			}
+
+			tick = timer.C()
+		} else if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+
+		select {
+		case <-tick:
+			timer = nil
+
+			if d.IsStatus(workers.DispatcherStatusProcess) {
+				d.autoscaleWorkers()
+			}
+
+		case <-d.autoscaleChanged:
+
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *SimpleDispatcher) autoscaleWorkers() {
+	h, _ := d.autoscale.Load().(autoscaleHolder)
+	if h.factory == nil {
+		return
+	}
+
+	waiting := 0
+	for _, item := range d.tasks.GetAll() {
+		if !item.IsLocked() {
+			waiting++
+		}
+	}
+
+	var (
+		total int
+		free  int
+		idle  []*manager.WorkersManagerItem
+	)
+
+	for _, item := range d.workers.GetAll() {
+		workerItem := item.(*manager.WorkersManagerItem)
+		if workerItem.IsRetired() || workerItem.IsStatus(workers.WorkerStatusCancel) {
+			continue
+		}
+
+		total++
+
+		inFlight := int(workerItem.InFlight())
+		free += workerItem.Capacity() - inFlight
+
+		if inFlight == 0 {
+			idle = append(idle, workerItem)
+		}
+	}
+
+	switch {
+	case total < h.min || (waiting > free && total < h.max):
+		need := waiting - free
+		if need < h.min-total {
+			need = h.min - total
+		}
+
+		if need > h.max-total {
+			need = h.max - total
+		}
+
+		added := 0
+		for ; added < need; added++ {
+			if err := d.AddWorker(h.factory()); err != nil {
+				d.Logger().Error("Autoscale add worker failed", "error", err)
+				break
+			}
+		}
+
+		if added > 0 {
+			d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerScaleUp, added, total+added)
+		}
+
+	case waiting == 0 && total > h.min && len(idle) > 0:
+		removed := total - h.min
+		if removed > len(idle) {
+			removed = len(idle)
+		}
+
+		for _, workerItem := range idle[:removed] {
+			d.retireWorkerItem(workerItem)
+		}
+
+		d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerScaleDown, removed, total-removed)
+	}
+}
@@ -0,0 +1,342 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/manager"
+)
+
+var (
+	ErrBroadcastNoWorkers     = errors.New("No workers to broadcast task")
+	ErrBroadcastWorkerRemoved = errors.New("Worker removed before broadcast task started on it")
+	ErrBroadcastPartCanceled  = fmt.Errorf("Broadcast task part canceled: %w", context.Canceled)
+)
+
+// итог выполнения широковещательной задачи на одном воркере
+type BroadcastResult struct {
+	WorkerId string
+	Result   interface{}
+	Err      error
+	// часть вернулась из RunTask воркера
+	Executed bool
+}
+
+// один проход широковещательной задачи по воркерам, зарегистрированным в момент её выдачи
+type broadcastRound struct {
+	mutex   sync.Mutex
+	item    *manager.TasksManagerItem
+	parts   []*manager.TasksManagerItem
+	results []BroadcastResult
+	pending int
+	// воркер, указываемый в событиях выполнения: запустивший первую часть и завершивший последнюю
+	worker  *manager.WorkersManagerItem
+	started uint32
+}
+
+// часть широковещательной задачи, закреплённая за одним воркером. Части хранятся вне менеджера задач,
+// поэтому не видны в метаданных, статистике и снимках состояния и не генерируют собственных событий
+type broadcastPart struct {
+	workers.Task
+
+	id     string
+	worker *manager.WorkersManagerItem
+	index  int
+	round  *broadcastRound
+	// итог части уже засчитан в общем результате
+	completed uint32
+}
+
+func (p *broadcastPart) Id() string {
+	return p.id
+}
+
+func (p *broadcastPart) HardTimeout() time.Duration {
+	if t, ok := p.Task.(workers.TaskWithHardTimeout); ok {
+		return t.HardTimeout()
+	}
+
+	return 0
+}
+
+// добавляет задачу, которая при выдаче выполняется по одному разу на каждом зарегистрированном воркере,
+// о выполнении сообщает одна пара событий: EventTaskExecuteStart с воркером, запустившим первую часть,
+// и EventTaskExecuteStop с воркером, завершившим последнюю. Результатом в EventTaskExecuteStop служит
+// []BroadcastResult в порядке воркеров, а признак выполнения выставлен, если хотя бы одна часть вернулась
+// из RunTask. Если задачу не принял ни один воркер, сообщается только EventTaskExecuteStop с воркером,
+// выбравшим её из очереди, и ошибкой ErrBroadcastNoWorkers.
+// Воркеры, добавленные во время выполнения, задачу не получают, а для удалённых до запуска части
+// в результате будет ErrBroadcastWorkerRemoved
+func (d *SimpleDispatcher) BroadcastTask(task workers.Task) error {
+	_, err := d.addTaskWithPrepare(task, nil, func(item *manager.TasksManagerItem) {
+		d.broadcasts.Store(item, (*broadcastRound)(nil))
+	})
+	if err != nil {
+		return err
+	}
+
+	d.notifyAllowExecuteTasks()
+	return nil
+}
+
+func (d *SimpleDispatcher) isBroadcast(item *manager.TasksManagerItem) bool {
+	_, ok := d.broadcasts.Load(item)
+	return ok
+}
+
+// раскладывает задачу на части по текущим воркерам, сама задача остаётся выданной до завершения всех частей
+func (d *SimpleDispatcher) startBroadcast(item *manager.TasksManagerItem, worker *manager.WorkersManagerItem) {
+	task := item.Task()
+	round := &broadcastRound{
+		item:   item,
+		worker: worker,
+	}
+
+	for _, w := range d.workers.GetAll() {
+		workerItem := w.(*manager.WorkersManagerItem)
+		if workerItem.IsRetired() || workerItem.IsStatus(workers.WorkerStatusCancel) || !workerAccepts(workerItem.Worker(), task) {
+			continue
+		}
+
+		part := &broadcastPart{
+			Task:   task,
+			id:     workers.NewId(),
+			worker: workerItem,
+			index:  len(round.parts),
+			round:  round,
+		}
+
+		round.parts = append(round.parts, manager.NewTasksManagerItemWithClock(part, workers.TaskStatusWait, d.clock))
+		round.results = append(round.results, BroadcastResult{WorkerId: workerItem.Id()})
+	}
+
+	round.pending = len(round.parts)
+	d.broadcasts.Store(item, round)
+
+	item.SetAttempts(item.Attempts() + 1)
+	d.setStatusTask(item, workers.TaskStatusProcess)
+
+	now := d.clock.Now()
+	if item.Attempts() == 1 {
+		item.SetFirstStartedAt(now)
+	}
+	item.SetLastStartedAt(now)
+
+	if len(round.parts) == 0 {
+		d.finishBroadcast(round, ErrBroadcastNoWorkers)
+		return
+	}
+
+	for _, part := range round.parts {
+		d.queueBroadcastPart(part)
+	}
+}
+
+// ставит часть в очередь её воркера, часть удалённого воркера сразу завершается
+func (d *SimpleDispatcher) queueBroadcastPart(item *manager.TasksManagerItem) {
+	part := item.Task().(*broadcastPart)
+	id := part.worker.Id()
+
+	d.broadcastMutex.Lock()
+	removed := d.workers.GetById(id) == nil
+	if !removed {
+		d.broadcastParts[id] = append(d.broadcastParts[id], item)
+	}
+	d.broadcastMutex.Unlock()
+
+	if removed {
+		d.completeBroadcastPart(part, nil, ErrBroadcastWorkerRemoved, false)
+	}
+}
+
+// возвращает часть, не выданную из-за ограничения частоты запусков, в начало очереди воркера
+func (d *SimpleDispatcher) requeueBroadcastPart(item *manager.TasksManagerItem) {
+	id := item.Task().(*broadcastPart).worker.Id()
+
+	d.broadcastMutex.Lock()
+	defer d.broadcastMutex.Unlock()
+
+	d.broadcastParts[id] = append([]*manager.TasksManagerItem{item}, d.broadcastParts[id]...)
+}
+
+// забирает следующую часть, ожидающую воркер
+func (d *SimpleDispatcher) pullBroadcastPart(worker *manager.WorkersManagerItem) *manager.TasksManagerItem {
+	d.broadcastMutex.Lock()
+	defer d.broadcastMutex.Unlock()
+
+	queue := d.broadcastParts[worker.Id()]
+	if len(queue) == 0 {
+		return nil
+	}
+
+	if len(queue) == 1 {
+		delete(d.broadcastParts, worker.Id())
+	} else {
+		d.broadcastParts[worker.Id()] = queue[1:]
+	}
+
+	return queue[0]
+}
+
+// части, не дождавшиеся удалённого воркера, завершаются без запуска
+func (d *SimpleDispatcher) failBroadcastParts(worker *manager.WorkersManagerItem) {
+	d.broadcastMutex.Lock()
+	queue := d.broadcastParts[worker.Id()]
+	delete(d.broadcastParts, worker.Id())
+	d.broadcastMutex.Unlock()
+
+	for _, item := range queue {
+		item.SetStatus(workers.TaskStatusCancel)
+		d.completeBroadcastPart(item.Task().(*broadcastPart), nil, ErrBroadcastWorkerRemoved, false)
+	}
+}
+
+// о запуске широковещательной задачи сообщается один раз, с воркером её первой части
+func (d *SimpleDispatcher) triggerBroadcastStart(part *broadcastPart, worker *manager.WorkersManagerItem) {
+	if !atomic.CompareAndSwapUint32(&part.round.started, 0, 1) {
+		return
+	}
+
+	item := part.round.item
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStart, item.Task(), item.Metadata(), worker.Worker(), worker.Metadata())
+}
+
+// задача и метаданные, под которыми выполнение видно слушателям: для части это исходная задача
+func eventTask(item *manager.TasksManagerItem) (workers.Task, workers.Metadata) {
+	if part, ok := item.Task().(*broadcastPart); ok {
+		return part.Task, part.round.item.Metadata()
+	}
+
+	return item.Task(), item.Metadata()
+}
+
+// часть отменённой широковещательной задачи запускать уже не нужно
+func isCompletedBroadcastPart(item *manager.TasksManagerItem) bool {
+	part, ok := item.Task().(*broadcastPart)
+	return ok && atomic.LoadUint32(&part.completed) == 1
+}
+
+// итог части фиксируется только в общем результате, событий о самой части не генерируется
+func (d *SimpleDispatcher) doBroadcastPartResult(part *broadcastPart, result SimpleDispatcherResult) {
+	err := result.err
+
+	switch {
+	case result.cancel:
+		result.taskItem.SetStatus(workers.TaskStatusCancel)
+		err = ErrBroadcastPartCanceled
+	case result.err != nil:
+		result.taskItem.SetStatus(failStatus(result.err))
+	default:
+		result.taskItem.SetStatus(workers.TaskStatusSuccess)
+	}
+
+	d.completeBroadcastPart(part, result.result, err, result.executed)
+}
+
+// итог каждой части засчитывается один раз, даже если отмена части пересеклась с её завершением
+func (d *SimpleDispatcher) completeBroadcastPart(part *broadcastPart, result interface{}, err error, executed bool) {
+	if !atomic.CompareAndSwapUint32(&part.completed, 0, 1) {
+		return
+	}
+
+	round := part.round
+
+	round.mutex.Lock()
+	round.results[part.index].Result = result
+	round.results[part.index].Err = err
+	round.results[part.index].Executed = executed
+	round.pending--
+	done := round.pending == 0
+	if done {
+		round.worker = part.worker
+	}
+	round.mutex.Unlock()
+
+	if done {
+		var errs []error
+		for _, r := range round.results {
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+			}
+		}
+
+		d.finishBroadcast(round, errors.Join(errs...))
+	}
+}
+
+func (d *SimpleDispatcher) finishBroadcast(round *broadcastRound, err error) {
+	item := round.item
+
+	// отменённая задача итогов не сообщает
+	if item.IsRemoved() {
+		return
+	}
+
+	var executed bool
+	for _, r := range round.results {
+		executed = executed || r.Executed
+	}
+
+	if d.ctx.Err() != nil {
+		d.broadcasts.Delete(item)
+		d.setStatusTask(item, workers.TaskStatusCancel)
+		d.collectStatusDurations(item)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, item.Task(), item.Metadata(), round.worker.Worker(), round.worker.Metadata(), round.results, err, true, executed)
+		return
+	}
+
+	if err != nil {
+		d.countError(err)
+		d.setStatusTask(item, failStatus(err))
+	} else {
+		d.setStatusTask(item, workers.TaskStatusSuccess)
+	}
+
+	d.scheduleNextRun(item, err)
+	if item.IsRemoved() {
+		d.retainResult(item, round.results, err)
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, item.Task(), item.Metadata(), round.worker.Worker(), round.worker.Metadata(), round.results, err, false, executed)
+	d.notifyAllowExecuteTasks()
+}
+
+// отмена широковещательной задачи отменяет и все её части
+func (d *SimpleDispatcher) cancelBroadcast(item *manager.TasksManagerItem) {
+	value, ok := d.broadcasts.LoadAndDelete(item)
+	if !ok || value.(*broadcastRound) == nil {
+		return
+	}
+
+	round := value.(*broadcastRound)
+
+	d.broadcastMutex.Lock()
+	for _, part := range round.parts {
+		id := part.Task().(*broadcastPart).worker.Id()
+		queue := make([]*manager.TasksManagerItem, 0, len(d.broadcastParts[id]))
+
+		for _, queued := range d.broadcastParts[id] {
+			if queued != part {
+				queue = append(queue, queued)
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(d.broadcastParts, id)
+		} else {
+			d.broadcastParts[id] = queue
+		}
+	}
+	d.broadcastMutex.Unlock()
+
+	// итоги частей отменённой задачи уже не засчитываются
+	for _, part := range round.parts {
+		atomic.StoreUint32(&part.Task().(*broadcastPart).completed, 1)
+		part.Cancel()
+	}
+}
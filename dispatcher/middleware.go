@@ -0,0 +1,38 @@
+package dispatcher
+
+import (
+	"github.com/mrsmtvd/go-workers"
+)
+
+// обёртка вокруг выполнения задачи воркером для сквозной логики: журналирования, трассировки, метрик
+type Middleware func(next workers.RunFunc) workers.RunFunc
+
+// добавляет обёртки к уже установленным, первая добавленная оказывается внешней и вызывается первой,
+// паника внутри цепочки по-прежнему превращается в ошибку диспетчером
+func (d *SimpleDispatcher) Use(m ...Middleware) {
+	d.middlewaresMutex.Lock()
+	defer d.middlewaresMutex.Unlock()
+
+	current, _ := d.middlewares.Load().([]Middleware)
+
+	tmp := make([]Middleware, 0, len(current)+len(m))
+	tmp = append(tmp, current...)
+
+	for _, middleware := range m {
+		if middleware != nil {
+			tmp = append(tmp, middleware)
+		}
+	}
+
+	d.middlewares.Store(tmp)
+}
+
+func (d *SimpleDispatcher) chainMiddlewares(run workers.RunFunc) workers.RunFunc {
+	middlewares, _ := d.middlewares.Load().([]Middleware)
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		run = middlewares[i](run)
+	}
+
+	return run
+}
@@ -0,0 +1,86 @@
+package dispatcher
+
+import (
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/manager"
+)
+
+// выбирает воркер для задачи среди свободных воркеров, готовых её принять, candidates не бывает пустым,
+// nil или воркер не из candidates оставляют задачу в очереди до следующего прохода.
+// Вызывается синхронно из цикла распределения задач, поэтому должен быть дешёвым
+type WorkerSelector interface {
+	Select(task workers.Task, candidates []*manager.WorkersManagerItem) *manager.WorkersManagerItem
+}
+
+type WorkerSelectorFunc func(workers.Task, []*manager.WorkersManagerItem) *manager.WorkersManagerItem
+
+func (f WorkerSelectorFunc) Select(task workers.Task, candidates []*manager.WorkersManagerItem) *manager.WorkersManagerItem {
+	return f(task, candidates)
+}
+
+type workerSelectorHolder struct {
+	selector WorkerSelector
+}
+
+// стратегия выбора воркера, по умолчанию задача достаётся первому свободному воркеру из очереди, nil возвращает её
+func (d *SimpleDispatcher) SetWorkerSelector(selector WorkerSelector) {
+	d.workerSelector.Store(workerSelectorHolder{selector: selector})
+}
+
+func (d *SimpleDispatcher) WorkerSelector() WorkerSelector {
+	if h, ok := d.workerSelector.Load().(workerSelectorHolder); ok {
+		return h.selector
+	}
+
+	return nil
+}
+
+// забирает из очереди все свободные воркеры, готовые принять задачу, и отдаёт выбор селектору,
+// остальные воркеры возвращаются в очередь
+func (d *SimpleDispatcher) selectWorker(worker *manager.WorkersManagerItem, task workers.Task) *manager.WorkersManagerItem {
+	selector := d.WorkerSelector()
+	if selector == nil {
+		return d.pullAcceptingWorker(worker, task)
+	}
+
+	var (
+		candidates []*manager.WorkersManagerItem
+		refused    []*manager.WorkersManagerItem
+	)
+
+	for worker != nil {
+		if workerAccepts(worker.Worker(), task) {
+			candidates = append(candidates, worker)
+		} else {
+			refused = append(refused, worker)
+		}
+
+		next := d.pullWorker()
+		if next == nil {
+			break
+		}
+
+		worker = next.(*manager.WorkersManagerItem)
+	}
+
+	var selected *manager.WorkersManagerItem
+	if len(candidates) > 0 {
+		selected = selector.Select(task, candidates)
+	}
+
+	found := false
+	for _, w := range append(candidates, refused...) {
+		if w == selected {
+			found = true
+			continue
+		}
+
+		_ = d.workers.Push(w)
+	}
+
+	if !found {
+		return nil
+	}
+
+	return selected
+}
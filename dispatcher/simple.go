@@ -3,12 +3,31 @@ package dispatcher
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/cache"
+	"github.com/mrsmtvd/go-workers/listener"
 	"github.com/mrsmtvd/go-workers/manager"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrTaskAlreadyQueued = errors.New("Task already queued")
+	ErrTaskDeadline      = fmt.Errorf("Task deadline passed before start: %w", context.DeadlineExceeded)
+	ErrDuplicateTaskId   = errors.New("Task with the same id already exists")
+	ErrDuplicateWorkerId = manager.ErrDuplicateWorkerId
+	ErrTaskNotFound      = errors.New("Task not found")
+
+	ErrIllegalStatusTransition = errors.New("Illegal dispatcher status transition")
 )
 
 type SimpleDispatcherResult struct {
@@ -17,6 +36,63 @@ type SimpleDispatcherResult struct {
 	result     interface{}
 	err        error
 	cancel     bool
+	// итог получен из возврата RunTask, а не из отмены или истечения времени
+	executed bool
+}
+
+// минимальное количество выполнений в окне, начиная с которого оценивается доля ошибок
+const circuitBreakerMinResults = 10
+
+// EventWorkerIdle и EventTaskStarved генерируются каждое не чаще этого интервала
+const poolEventsInterval = time.Second
+
+// буфер канала событий по умолчанию
+const defaultEventsBufferSize = 64
+
+// период страховочного прохода при отключённом тикере, если в очереди остались задачи, о готовности
+// которых диспетчер не узнает по событиям
+const fallbackWakeupInterval = time.Second
+
+type circuitResult struct {
+	at     time.Time
+	failed bool
+}
+
+type profilerHolder struct {
+	profiler workers.Profiler
+}
+
+type resultCacheHolder struct {
+	cache workers.ResultCache
+}
+
+type resultRetentionHolder struct {
+	cache *cache.LRUCache
+	ttl   time.Duration
+}
+
+type retainedResult struct {
+	task    workers.Task
+	result  interface{}
+	err     error
+	history []workers.ExecutionRecord
+}
+
+type rateLimiterHolder struct {
+	limiter *rate.Limiter
+}
+
+type contextDecoratorHolder struct {
+	decorator func(context.Context, workers.Task) context.Context
+}
+
+type deadLetterHandlerHolder struct {
+	handler func(workers.Task, error)
+}
+
+type eventsOverflowHolder struct {
+	policy manager.EventsOverflowPolicy
+	size   int
 }
 
 type SimpleDispatcher struct {
@@ -24,17 +100,73 @@ type SimpleDispatcher struct {
 
 	_ [4]byte // atomic requires 64-bit alignment for struct field access
 	workers.StatusItemBase
+	abandonedTasks   int64
+	heartbeatTimeout int64
+	heartbeatPeriod  int64
+	shutdownTimeout  int64
+	defaultTimeout   int64
+	workerIdleAt     int64
+	taskStarvedAt    int64
+	maxAttempts      int64
+	maxLifetime      int64
+	runningTasks     int64
+	maxConcurrent    int64
+	autoscalePeriod  int64
+	historySize      int64
+	resultBuffer     int64
+	resultCollectors int64
+	tickerPeriod     int64
+	dispatching      uint32
+	deduplication    uint32
 
 	ctx       context.Context
 	ctxCancel context.CancelFunc
+	clock     clock.Clock
 
 	workers   workers.Manager
 	tasks     workers.Manager
 	listeners *manager.ListenersManager
 
+	// широковещательные задачи и их текущие проходы
+	broadcasts sync.Map
+	// ожидающие запуска части широковещательных задач по идентификаторам воркеров
+	broadcastMutex sync.Mutex
+	broadcastParts map[string][]*manager.TasksManagerItem
+	// отписки от контекстов задач, добавленных через AddTaskWithContext
+	contextWatches sync.Map
+
 	allowExecuteTasks       chan struct{}
 	tickerAllowExecuteTasks *workers.Ticker
 	results                 chan SimpleDispatcherResult
+	profiler                atomic.Value
+	resultCache             atomic.Value
+	rateLimiter             atomic.Value
+	resultRetention         atomic.Value
+	contextDecorator        atomic.Value
+	deadLetterHandler       atomic.Value
+	workerSelector          atomic.Value
+	middlewares             atomic.Value
+	autoscale               atomic.Value
+	autoscaleChanged        chan struct{}
+	eventsOverflow          atomic.Value
+	middlewaresMutex        sync.Mutex
+	idle                    chan struct{}
+	stateMutex              sync.Mutex
+	stateChanged            chan struct{}
+	started                 chan struct{}
+	stopped                 chan struct{}
+	addMutex                sync.Mutex
+
+	circuitMutex    sync.Mutex
+	circuitRate     float64
+	circuitWindow   time.Duration
+	circuitCooldown time.Duration
+	circuitResults  []circuitResult
+	circuitTimer    *time.Timer
+
+	statsMutex           sync.RWMutex
+	errorsCategories     map[string]int64
+	tasksStatusDurations map[workers.TaskStatus]time.Duration
 }
 
 func NewSimpleDispatcher() *SimpleDispatcher {
@@ -42,16 +174,55 @@ func NewSimpleDispatcher() *SimpleDispatcher {
 }
 
 func NewSimpleDispatcherWithContext(ctx context.Context) *SimpleDispatcher {
+	return NewSimpleDispatcherWithClock(ctx, clock.NewClock())
+}
+
+// диспетчер, берущий текущее время и тики цикла распределения из переданных часов,
+// позволяет детерминированно проверять отложенные запуски с fakeclock
+func NewSimpleDispatcherWithClock(ctx context.Context, c clock.Clock) *SimpleDispatcher {
+	return newSimpleDispatcher(ctx, c, manager.NewTasksManagerWithClock(c), manager.NewWorkersManager())
+}
+
+// диспетчер с собственными хранилищами задач и воркеров, например, переживающими перезапуск, nil заменяется
+// хранилищем в памяти. Хранилище должно возвращать те элементы, что получило в Push (*manager.TasksManagerItem
+// и *manager.WorkersManagerItem), и выдавать через Pull только незаблокированные
+func NewSimpleDispatcherWithManagers(ctx context.Context, tasksManager, workersManager workers.Manager) *SimpleDispatcher {
+	c := clock.NewClock()
+
+	if tasksManager == nil {
+		tasksManager = manager.NewTasksManagerWithClock(c)
+	}
+
+	if workersManager == nil {
+		workersManager = manager.NewWorkersManager()
+	}
+
+	return newSimpleDispatcher(ctx, c, tasksManager, workersManager)
+}
+
+func newSimpleDispatcher(ctx context.Context, c clock.Clock, tasksManager, workersManager workers.Manager) *SimpleDispatcher {
 	d := &SimpleDispatcher{
-		workers:                 manager.NewWorkersManager(),
-		tasks:                   manager.NewTasksManager(),
+		clock:                   c,
+		workers:                 workersManager,
+		tasks:                   tasksManager,
 		listeners:               manager.NewListenersManager(),
 		allowExecuteTasks:       make(chan struct{}, 1),
-		tickerAllowExecuteTasks: workers.NewTicker(time.Second),
+		tickerAllowExecuteTasks: workers.NewTickerWithClock(c, time.Second),
+		tickerPeriod:            int64(time.Second),
 		results:                 make(chan SimpleDispatcherResult),
+		idle:                    make(chan struct{}, 1),
+		autoscaleChanged:        make(chan struct{}, 1),
+		stateChanged:            make(chan struct{}),
+		started:                 make(chan struct{}),
+		stopped:                 make(chan struct{}),
+		errorsCategories:        map[string]int64{},
+		broadcastParts:          map[string][]*manager.TasksManagerItem{},
+		tasksStatusDurations:    map[workers.TaskStatus]time.Duration{},
 	}
 
 	d.setStatusDispatcher(workers.DispatcherStatusWait)
+	d.SetResultCache(cache.NewMemoryCache())
+	d.listeners.StopAsync()
 
 	d.ctx, d.ctxCancel = context.WithCancel(ctx)
 	return d
@@ -61,16 +232,54 @@ func (d *SimpleDispatcher) Context() context.Context {
 	return d.ctx
 }
 
+func (d *SimpleDispatcher) Done() <-chan struct{} {
+	return d.ctx.Done()
+}
+
+func (d *SimpleDispatcher) Err() error {
+	return d.ctx.Err()
+}
+
 func (d *SimpleDispatcher) Run() error {
-	if !d.IsStatus(workers.DispatcherStatusWait) {
+	if err := d.acquireRun(); err != nil {
+		return err
+	}
+
+	return d.run()
+}
+
+// переводит диспетчер в Process, из одновременных запусков успешен только один, остальные получают ошибку
+func (d *SimpleDispatcher) acquireRun() error {
+	// с уже отменённым контекстом диспетчер не запускается, чтобы вызывающий не принял это за штатную остановку
+	if err := d.ctx.Err(); err != nil {
+		return fmt.Errorf("Dispatcher context is done before run: %w", err)
+	}
+
+	if !d.swapStatusDispatcher(workers.DispatcherStatusWait, workers.DispatcherStatusProcess) {
 		return errors.New("Dispatcher is running")
 	}
 
-	d.setStatusDispatcher(workers.DispatcherStatusProcess)
+	return nil
+}
+
+func (d *SimpleDispatcher) run() error {
+	if lifetime := d.MaxLifetime(); lifetime > 0 {
+		stop := d.afterFunc(lifetime, d.expireLifetime)
+		defer stop()
+	}
+
+	collectors := d.ResultCollectors()
+	d.results = make(chan SimpleDispatcherResult, d.ResultBufferSize())
 
-	go d.doResultCollector()
+	d.wg.Add(collectors + 2)
+	for i := 0; i < collectors; i++ {
+		go d.doResultCollector()
+	}
 	go d.doDispatch()
+	go d.doAutoscale()
+	d.listeners.StartAsync()
 	d.notifyAllowExecuteTasks()
+	close(d.started)
 
 	<-d.ctx.Done()
 	d.setStatusDispatcher(workers.DispatcherStatusCancel)
@@ -80,333 +289,2658 @@ func (d *SimpleDispatcher) Run() error {
 	}
 
 	for _, t := range d.tasks.GetAll() {
-		d.setStatusTask(t, workers.WorkerStatusCancel)
+		d.setStatusTask(t, workers.TaskStatusCancel)
 	}
 
 	d.wg.Wait()
-	d.setStatusDispatcher(workers.DispatcherStatusWait)
 
-	return nil
-}
-
-func (d *SimpleDispatcher) Cancel() error {
-	d.ctxCancel()
-	return d.ctx.Err()
-}
+	// сборщики могли завершиться раньше, чем последний результат попал в буфер
+	for drained := false; !drained; {
+		select {
+		case result := <-d.results:
+			d.doResult(result)
+		default:
+			drained = true
+		}
+	}
 
-func (d *SimpleDispatcher) Metadata() workers.Metadata {
-	return workers.Metadata{
-		workers.DispatcherMetadataStatus: d.Status(),
+	for _, w := range d.workers.GetAll() {
+		d.stopWorker(w.(*manager.WorkersManagerItem))
 	}
-}
 
-func (d *SimpleDispatcher) Status() workers.DispatcherStatus {
-	return workers.DispatcherStatus(d.StatusInt64())
-}
+	// обработчики очереди асинхронных слушателей живут только вместе с диспетчером
+	d.listeners.StopAsync()
+	d.setStatusDispatcher(workers.DispatcherStatusWait)
+	close(d.stopped)
 
-func (d *SimpleDispatcher) SetStatus(status workers.Status) {
-	panic("Change status nof allowed")
+	return nil
 }
 
-func (d *SimpleDispatcher) AddWorker(worker workers.Worker) error {
-	item := manager.NewWorkersManagerItem(worker, workers.WorkerStatusWait)
-	err := d.workers.Push(item)
-	if err != nil {
+// запускает Run в отдельной горутине и возвращается, когда диспетчер уже раздаёт задачи, поэтому задачи,
+// добавленные сразу после Start, гарантированно будут выполнены. Ошибка запуска Run возвращается сразу
+func (d *SimpleDispatcher) Start() error {
+	if err := d.acquireRun(); err != nil {
 		return err
 	}
 
-	d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerAdd, worker, item.Metadata())
-	d.notifyAllowExecuteTasks()
+	go d.run()
+
+	<-d.started
 	return nil
 }
 
-func (d *SimpleDispatcher) RemoveWorker(worker workers.Worker) {
-	item := d.workers.GetById(worker.Id())
-	if item != nil {
-		d.setStatusWorker(item, workers.WorkerStatusCancel)
+// отменяет диспетчер и дожидается возврата Run, при истечении ctx раньше возвращается его ошибка
+func (d *SimpleDispatcher) Stop(ctx context.Context) error {
+	select {
+	case <-d.started:
+	default:
+		return errors.New("Dispatcher isn't running")
+	}
 
-		workerItem := item.(*manager.WorkersManagerItem)
-		workerItem.Cancel()
-		workerItem.SetTask(nil)
+	d.Cancel()
 
-		d.workers.Remove(item)
-		d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerRemove, workerItem.Worker(), workerItem.Metadata())
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-func (d *SimpleDispatcher) GetWorkerMetadata(id string) workers.Metadata {
-	if item := d.workers.GetById(id); item != nil {
-		return item.Metadata()
+// запускает диспетчер и при получении одного из сигналов (по умолчанию SIGINT и SIGTERM)
+// плавно останавливает его, дожидаясь выполняющихся задач не дольше ShutdownTimeout
+func (d *SimpleDispatcher) RunWithSignals(sigs ...os.Signal) error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
 	}
 
-	return nil
-}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sigs...)
+	defer signal.Stop(signals)
 
-func (d *SimpleDispatcher) GetWorkers() []workers.Worker {
-	all := d.workers.GetAll()
-	collection := make([]workers.Worker, 0, len(all))
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run()
+	}()
 
-	for _, item := range all {
-		collection = append(collection, item.(*manager.WorkersManagerItem).Worker())
+	select {
+	case err := <-done:
+		return err
+	case <-signals:
 	}
 
-	return collection
-}
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	if timeout := d.ShutdownTimeout(); timeout > 0 {
+		ctx, ctxCancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer ctxCancel()
 
-func (d *SimpleDispatcher) AddTask(task workers.Task) error {
-	item := manager.NewTasksManagerItem(task, workers.TaskStatusWait)
-	err := d.tasks.Push(item)
-	if err != nil {
+	shutdownErr := d.Shutdown(ctx)
+	if shutdownErr != nil {
+		d.Cancel()
+	}
+
+	if err := <-done; err != nil {
 		return err
 	}
 
-	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskAdd, task, item.Metadata())
-	d.notifyAllowExecuteTasks()
-	return nil
+	return shutdownErr
 }
 
-func (d *SimpleDispatcher) RemoveTask(task workers.Task) {
-	item := d.tasks.GetById(task.Id())
-	if item != nil {
-		d.setStatusTask(item, workers.TaskStatusCancel)
+// плавная остановка: диспетчер переходит в статус Shutdown и перестаёт запускать новые задачи,
+// выполняющиеся задачи дожидаются завершения, пока не отменён ctx, после чего оставшиеся отменяются.
+// Метод возвращается только после того, как завершился Run, при истечении ctx возвращается его ошибка
+func (d *SimpleDispatcher) Shutdown(ctx context.Context) error {
+	if !d.swapStatusDispatcher(workers.DispatcherStatusProcess, workers.DispatcherStatusShutdown) &&
+		!d.swapStatusDispatcher(workers.DispatcherStatusPause, workers.DispatcherStatusShutdown) {
+		return errors.New("Dispatcher isn't running")
+	}
 
-		taskItem := item.(*manager.TasksManagerItem)
-		taskItem.Cancel()
+	var err error
 
-		d.tasks.Remove(item)
-		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, taskItem.Task(), taskItem.Metadata())
+	for err == nil && atomic.LoadInt64(&d.runningTasks) > 0 {
+		select {
+		case <-d.idle:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
 	}
-}
 
-func (d *SimpleDispatcher) GetTaskMetadata(id string) workers.Metadata {
-	if item := d.tasks.GetById(id); item != nil {
-		return item.Metadata()
-	}
+	d.Cancel()
+	<-d.stopped
 
-	return nil
+	return err
 }
 
-func (d *SimpleDispatcher) GetTasks() []workers.Task {
-	all := d.tasks.GetAll()
-	collection := make([]workers.Task, 0, len(all))
-
-	for _, item := range all {
-		collection = append(collection, item.(*manager.TasksManagerItem).Task())
+// приостанавливает запуск новых задач, выполняющиеся задачи завершаются как обычно
+func (d *SimpleDispatcher) Pause() error {
+	if !d.swapStatusDispatcher(workers.DispatcherStatusProcess, workers.DispatcherStatusPause) {
+		return errors.New("Dispatcher isn't running")
 	}
 
-	return collection
-}
-
-func (d *SimpleDispatcher) AddListener(eventId workers.Event, listener workers.Listener) error {
-	d.listeners.Attach(eventId, listener)
-	d.listeners.AsyncTrigger(d.Context(), workers.EventListenerAdd, eventId, listener, d.GetListenerMetadata(listener.Id()))
-
 	return nil
 }
 
-func (d *SimpleDispatcher) RemoveListener(eventId workers.Event, listener workers.Listener) {
-	item := d.listeners.GetById(listener.Id())
-	if item != nil {
-		d.listeners.DeAttach(eventId, listener)
-		d.listeners.AsyncTrigger(d.Context(), workers.EventListenerRemove, eventId, listener, item.Metadata())
+func (d *SimpleDispatcher) Resume() error {
+	if !d.swapStatusDispatcher(workers.DispatcherStatusPause, workers.DispatcherStatusProcess) {
+		return errors.New("Dispatcher isn't paused")
 	}
-}
 
-func (d *SimpleDispatcher) GetListenerMetadata(id string) workers.Metadata {
-	if item := d.listeners.GetById(id); item != nil {
-		return item.Metadata()
+	d.circuitMutex.Lock()
+	if d.circuitTimer != nil {
+		d.circuitTimer.Stop()
+		d.circuitTimer = nil
 	}
+	d.circuitMutex.Unlock()
 
+	d.notifyAllowExecuteTasks()
 	return nil
 }
 
-func (d *SimpleDispatcher) GetListeners() []workers.Listener {
-	return d.listeners.Listeners()
+// включает глобальное размыкание: если доля провалившихся выполнений за окно window превышает rate,
+// диспетчер приостанавливается и возобновляется через cooldown, при нулевом cooldown только вручную через Resume.
+// Доля оценивается, когда в окне набралось не меньше circuitBreakerMinResults выполнений, rate <= 0 отключает размыкание
+func (d *SimpleDispatcher) SetGlobalCircuitBreaker(rate float64, window time.Duration, cooldown time.Duration) {
+	d.circuitMutex.Lock()
+	defer d.circuitMutex.Unlock()
+
+	d.circuitRate = rate
+	d.circuitWindow = window
+	d.circuitCooldown = cooldown
+	d.circuitResults = nil
 }
 
-func (d *SimpleDispatcher) doResultCollector() {
-	d.wg.Add(1)
-	defer d.wg.Done()
-
-	for {
-		select {
-		case result := <-d.results:
-			result.taskItem.SetCancel(nil)
-			result.workerItem.SetCancel(nil)
+func (d *SimpleDispatcher) recordCircuitResult(failed bool) {
+	d.circuitMutex.Lock()
+	defer d.circuitMutex.Unlock()
 
-			if d.IsStatus(workers.DispatcherStatusCancel) {
-				continue
-			}
+	if d.circuitRate <= 0 {
+		return
+	}
 
-			result.workerItem.SetTask(nil)
+	now := d.clock.Now()
+	results := d.circuitResults[:0]
 
-			if !result.cancel || !result.workerItem.IsStatus(workers.WorkerStatusCancel) {
-				d.setStatusWorker(result.workerItem, workers.WorkerStatusWait)
-				if err := d.workers.Push(result.workerItem); err != nil {
-					log.Printf("Push worker failed with error: %s", err.Error())
-				}
-			}
+	for _, r := range d.circuitResults {
+		if now.Sub(r.at) <= d.circuitWindow {
+			results = append(results, r)
+		}
+	}
 
-			if !result.cancel && !result.taskItem.IsStatus(workers.TaskStatusCancel) {
-				if result.err != nil {
-					d.setStatusTask(result.taskItem, workers.TaskStatusFail)
-				} else {
-					d.setStatusTask(result.taskItem, workers.TaskStatusSuccess)
-				}
+	d.circuitResults = append(results, circuitResult{at: now, failed: failed})
+	if len(d.circuitResults) < circuitBreakerMinResults {
+		return
+	}
 
-				if repeats := result.taskItem.Task().Repeats(); repeats < 0 || result.taskItem.Attempts() < repeats {
-					repeatInterval := result.taskItem.Task().RepeatInterval()
-					if repeatInterval > 0 {
-						result.taskItem.SetAllowStartAt(time.Now().Add(repeatInterval))
-					}
+	var fails int
+	for _, r := range d.circuitResults {
+		if r.failed {
+			fails++
+		}
+	}
 
-					d.setStatusTask(result.taskItem, workers.TaskStatusRepeatWait)
-					if err := d.tasks.Push(result.taskItem); err != nil {
-						log.Printf("Push task failed with error: %s", err.Error())
-					}
-				} else {
-					d.tasks.Remove(result.taskItem)
-				}
-			}
+	rate := float64(fails) / float64(len(d.circuitResults))
+	if rate <= d.circuitRate || !d.swapStatusDispatcher(workers.DispatcherStatusProcess, workers.DispatcherStatusPause) {
+		return
+	}
 
-			d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, result.taskItem.Task(), result.taskItem.Metadata(), result.workerItem.Worker(), result.workerItem.Metadata(), result.result, result.err)
-			d.notifyAllowExecuteTasks()
+	d.circuitResults = nil
+	d.listeners.AsyncTrigger(d.Context(), workers.EventDispatcherCircuitOpen, d, rate)
 
-		case <-d.ctx.Done():
-			return
-		}
+	if d.circuitCooldown > 0 {
+		d.circuitTimer = time.AfterFunc(d.circuitCooldown, func() {
+			_ = d.Resume()
+		})
 	}
 }
 
-func (d *SimpleDispatcher) doDispatch() {
-	d.wg.Add(1)
-	defer d.wg.Done()
+// ограничивает время работы диспетчера, по истечении он плавно останавливается независимо от очереди,
+// выполняющиеся задачи ждут не дольше ShutdownTimeout, 0 снимает ограничение. Задаётся до запуска,
+// на уже работающий диспетчер не влияет
+func (d *SimpleDispatcher) SetMaxLifetime(lifetime time.Duration) {
+	atomic.StoreInt64(&d.maxLifetime, int64(lifetime))
+}
 
-	for {
-		select {
-		case <-d.allowExecuteTasks:
-			d.doExecuteTasks()
+func (d *SimpleDispatcher) MaxLifetime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.maxLifetime))
+}
 
-		case <-d.tickerAllowExecuteTasks.C():
-			d.doExecuteTasks()
+func (d *SimpleDispatcher) expireLifetime() {
+	d.listeners.AsyncTrigger(d.Context(), workers.EventDispatcherLifetimeExpired, d, d.MaxLifetime())
 
-		case <-d.ctx.Done():
-			d.tickerAllowExecuteTasks.Stop()
-			return
-		}
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	if timeout := d.ShutdownTimeout(); timeout > 0 {
+		ctx, ctxCancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer ctxCancel()
+
+	if err := d.Shutdown(ctx); err != nil {
+		d.Logger().Error("Shutdown after lifetime expired failed", "error", err)
 	}
 }
 
-func (d *SimpleDispatcher) doExecuteTasks() {
-	if !d.IsStatus(workers.DispatcherStatusProcess) {
-		return
+// ограничивает количество одновременно выполняющихся задач независимо от числа воркеров, 0 снимает ограничение
+func (d *SimpleDispatcher) SetMaxConcurrent(n int) {
+	if n < 0 {
+		n = 0
 	}
 
-	for {
-		pullWorker := d.workers.Pull()
-		pullTask := d.tasks.Pull()
+	atomic.StoreInt64(&d.maxConcurrent, int64(n))
+	d.notifyAllowExecuteTasks()
+}
 
-		if pullWorker != nil && pullTask != nil {
-			castWorker := pullWorker.(*manager.WorkersManagerItem)
-			castTask := pullTask.(*manager.TasksManagerItem)
-			if castTask.IsStatus(workers.TaskStatusCancel) {
-				d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, castTask.Task(), castTask.Metadata())
-				return
-			}
+func (d *SimpleDispatcher) MaxConcurrent() int {
+	return int(atomic.LoadInt64(&d.maxConcurrent))
+}
 
-			d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStart, castTask.Task(), castTask.Metadata(), castWorker.Worker(), castWorker.Metadata())
-			go d.doRunTask(castWorker, castTask)
-		} else {
-			if pullWorker != nil {
-				_ = d.workers.Push(pullWorker)
-			}
+// размер буфера результатов выполнения, 0 означает, что завершившаяся задача ждёт свободного сборщика.
+// Задаётся до запуска, на уже работающий диспетчер не влияет
+func (d *SimpleDispatcher) SetResultBufferSize(size int) {
+	if size < 0 {
+		size = 0
+	}
 
-			if pullTask != nil {
-				_ = d.tasks.Push(pullTask)
-			}
+	atomic.StoreInt64(&d.resultBuffer, int64(size))
+}
 
-			return
-		}
-	}
+func (d *SimpleDispatcher) ResultBufferSize() int {
+	return int(atomic.LoadInt64(&d.resultBuffer))
 }
 
-func (d *SimpleDispatcher) doRunTask(workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem) {
-	d.wg.Add(1)
-	defer d.wg.Done()
+// количество горутин, параллельно обрабатывающих результаты выполнения, по умолчанию одна.
+// Задаётся до запуска, на уже работающий диспетчер не влияет
+func (d *SimpleDispatcher) SetResultCollectors(n int) {
+	atomic.StoreInt64(&d.resultCollectors, int64(n))
+}
 
-	task := taskItem.Task()
+func (d *SimpleDispatcher) ResultCollectors() int {
+	if n := atomic.LoadInt64(&d.resultCollectors); n > 0 {
+		return int(n)
+	}
 
-	workerItem.SetTask(task)
-	d.setStatusWorker(workerItem, workers.WorkerStatusProcess)
+	return 1
+}
 
-	taskItem.SetAttempts(taskItem.Attempts() + 1)
-	d.setStatusTask(taskItem, workers.TaskStatusProcess)
+// время, которое RunWithSignals и истечение MaxLifetime дают выполняющимся задачам на завершение, 0 ждёт без ограничений
+func (d *SimpleDispatcher) SetShutdownTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&d.shutdownTimeout, int64(timeout))
+}
 
-	now := time.Now()
-	if taskItem.Attempts() == 1 {
-		taskItem.SetFirstStartedAt(now)
-	}
-	taskItem.SetLastStartedAt(now)
+func (d *SimpleDispatcher) ShutdownTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.shutdownTimeout))
+}
 
-	ctx := workers.NewContextWithAttempt(d.ctx, taskItem.Attempts())
+// таймаут для задач, у которых Timeout не задан, 0 оставляет такие задачи без ограничения
+func (d *SimpleDispatcher) SetDefaultTaskTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&d.defaultTimeout, int64(timeout))
+}
 
-	var ctxCancel context.CancelFunc
+func (d *SimpleDispatcher) DefaultTaskTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.defaultTimeout))
+}
 
-	timeout := task.Timeout()
-	if timeout > 0 {
-		ctx, ctxCancel = context.WithTimeout(ctx, timeout)
-	} else {
-		ctx, ctxCancel = context.WithCancel(ctx)
+// при включённой дедупликации повторное добавление незавершённой задачи с таким же Id считается ожидаемым
+// и AddTask отклоняет его с ErrTaskAlreadyQueued вместо ErrDuplicateTaskId
+func (d *SimpleDispatcher) SetDeduplication(enabled bool) {
+	var value uint32
+	if enabled {
+		value = 1
 	}
 
-	defer ctxCancel()
-	taskItem.SetCancel(ctxCancel)
-	workerItem.SetCancel(ctxCancel)
+	atomic.StoreUint32(&d.deduplication, value)
+}
 
-	done := make(chan SimpleDispatcherResult, 1)
+func (d *SimpleDispatcher) Deduplication() bool {
+	return atomic.LoadUint32(&d.deduplication) == 1
+}
 
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				done <- SimpleDispatcherResult{
-					workerItem: workerItem,
-					taskItem:   taskItem,
-					result:     err,
-					err:        errors.New("Panic recovered"),
-				}
+func (d *SimpleDispatcher) Cancel() error {
+	d.ctxCancel()
+	return d.ctx.Err()
+}
+
+func (d *SimpleDispatcher) Metadata() workers.Metadata {
+	return workers.Metadata{
+		workers.DispatcherMetadataStatus:               d.Status(),
+		workers.DispatcherMetadataAbandonedTasks:       d.AbandonedTasks(),
+		workers.DispatcherMetadataErrorCategories:      d.ErrorCategories(),
+		workers.DispatcherMetadataTasksStatusDurations: d.TasksStatusDurations(),
+	}
+}
+
+// количество горутин задач, которые проигнорировали отмену контекста и были брошены диспетчером
+func (d *SimpleDispatcher) AbandonedTasks() int64 {
+	return atomic.LoadInt64(&d.abandonedTasks)
+}
+
+// максимальный интервал между сигналами жизни задачи, после которого она считается зависшей,
+// 0 отключает слежение
+func (d *SimpleDispatcher) SetHeartbeatTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&d.heartbeatTimeout, int64(timeout))
+}
+
+func (d *SimpleDispatcher) HeartbeatTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.heartbeatTimeout))
+}
+
+// интервал, с которым для каждой выполняющейся задачи генерируется EventTaskHeartbeat с временем от её запуска,
+// 0 отключает события. Действует для задач, запущенных после изменения
+func (d *SimpleDispatcher) SetHeartbeatInterval(interval time.Duration) {
+	atomic.StoreInt64(&d.heartbeatPeriod, int64(interval))
+}
+
+func (d *SimpleDispatcher) HeartbeatInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.heartbeatPeriod))
+}
+
+// количество провалившихся выполнений задач в разбивке по категориям ошибок
+func (d *SimpleDispatcher) ErrorCategories() map[string]int64 {
+	d.statsMutex.RLock()
+	defer d.statsMutex.RUnlock()
+
+	tmp := make(map[string]int64, len(d.errorsCategories))
+	for category, count := range d.errorsCategories {
+		tmp[category] = count
+	}
+
+	return tmp
+}
+
+// суммарное время, проведённое в каждом статусе уже завершёнными задачами
+func (d *SimpleDispatcher) TasksStatusDurations() map[workers.TaskStatus]time.Duration {
+	d.statsMutex.RLock()
+	defer d.statsMutex.RUnlock()
+
+	tmp := make(map[workers.TaskStatus]time.Duration, len(d.tasksStatusDurations))
+	for status, duration := range d.tasksStatusDurations {
+		tmp[status] = duration
+	}
+
+	return tmp
+}
+
+func (d *SimpleDispatcher) Status() workers.DispatcherStatus {
+	return workers.DispatcherStatus(d.StatusInt64())
+}
+
+// то же, что ChangeStatus, недопустимый переход не паникует, а записывается в лог
+func (d *SimpleDispatcher) SetStatus(status workers.Status) {
+	if err := d.ChangeStatus(status); err != nil {
+		d.Logger().Error("Change dispatcher status failed", "status", status, "error", err)
+	}
+}
+
+// меняет статус через соответствующий метод жизненного цикла: Process и Pause переключаются через Pause и Resume,
+// в Cancel можно перейти из Process или Pause, установка текущего статуса ничего не делает.
+// Запуск и плавная остановка требуют Run и Shutdown, остальные переходы отклоняются с ErrIllegalStatusTransition
+func (d *SimpleDispatcher) ChangeStatus(status workers.Status) error {
+	next, ok := status.(workers.DispatcherStatus)
+	if !ok {
+		return fmt.Errorf("%w: %v isn't a dispatcher status", ErrIllegalStatusTransition, status)
+	}
+
+	current := d.Status()
+
+	switch {
+	case next == current:
+		return nil
+	case current == workers.DispatcherStatusProcess && next == workers.DispatcherStatusPause:
+		return d.Pause()
+	case current == workers.DispatcherStatusPause && next == workers.DispatcherStatusProcess:
+		return d.Resume()
+	case (current == workers.DispatcherStatusProcess || current == workers.DispatcherStatusPause) && next == workers.DispatcherStatusCancel:
+		_ = d.Cancel()
+		return nil
+	}
+
+	return fmt.Errorf("%w: from %s to %s", ErrIllegalStatusTransition, current, next)
+}
+
+// идентификаторы воркеров должны быть уникальны, воркер с уже занятым Id отклоняется с ErrDuplicateWorkerId
+func (d *SimpleDispatcher) AddWorker(worker workers.Worker) error {
+	// проверка до OnStart, чтобы не захватывать ресурсы воркера, который всё равно не будет добавлен
+	if d.workers.GetById(worker.Id()) != nil {
+		return ErrDuplicateWorkerId
+	}
+
+	if w, ok := worker.(workers.WorkerWithLifecycle); ok {
+		if err := w.OnStart(d.Context()); err != nil {
+			return err
+		}
+	}
+
+	item := manager.NewWorkersManagerItem(worker, workers.WorkerStatusWait)
+	err := d.workers.Push(item)
+	if err != nil {
+		d.stopWorker(item)
+		return err
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerAdd, worker, item.Metadata())
+	d.notifyAllowExecuteTasks()
+	return nil
+}
+
+func (d *SimpleDispatcher) RemoveWorker(worker workers.Worker) {
+	d.RemoveWorkerById(worker.Id())
+}
+
+// удаляет воркер по идентификатору, отменяя выполняемые им задачи, возвращает false если воркер не найден.
+// Занятый воркер выводится из пула как при автомасштабировании и удаляется после возврата отменённых задач
+func (d *SimpleDispatcher) RemoveWorkerById(id string) bool {
+	item := d.workers.GetById(id)
+	if item == nil {
+		return false
+	}
+
+	workerItem := item.(*manager.WorkersManagerItem)
+	workerItem.Retire()
+	d.setStatusWorker(workerItem, workers.WorkerStatusCancel)
+	workerItem.Cancel()
+
+	d.retireWorkerItem(workerItem)
+	return true
+}
+
+// вызывает OnStop воркера не более одного раза, контекст диспетчера к этому моменту может быть уже отменён
+func (d *SimpleDispatcher) stopWorker(item *manager.WorkersManagerItem) {
+	if !item.CompleteStop() {
+		return
+	}
+
+	if w, ok := item.Worker().(workers.WorkerWithLifecycle); ok {
+		w.OnStop(context.Background())
+	}
+}
+
+// временно добавляет n воркеров, созданных factory, для разбора накопившейся очереди, по истечении duration
+// они выводятся из пула, успевая завершить текущие задачи. Срок отсчитывается по часам диспетчера,
+// а при его остановке воркеры останавливаются вместе с остальными
+func (d *SimpleDispatcher) BoostConcurrency(n int, duration time.Duration, factory func() workers.Worker) error {
+	if n <= 0 || duration <= 0 {
+		return errors.New("Boost workers count and duration must be positive")
+	}
+
+	if factory == nil {
+		return errors.New("Boost workers factory is required")
+	}
+
+	boosted := make([]workers.Worker, 0, n)
+	for i := 0; i < n; i++ {
+		w := factory()
+		if err := d.AddWorker(w); err != nil {
+			for _, added := range boosted {
+				d.retireWorker(added)
+			}
+
+			return err
+		}
+
+		boosted = append(boosted, w)
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerBoostStart, n, duration)
+
+	d.afterFunc(duration, func() {
+		for _, w := range boosted {
+			d.retireWorker(w)
+		}
+
+		d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerBoostStop, n, duration)
+	})
+
+	return nil
+}
+
+// плавно заменяет воркер: новый добавляется сразу, старому перестают выдавать задачи,
+// и он удаляется после завершения текущей, метод возвращается после удаления старого воркера
+func (d *SimpleDispatcher) ReplaceWorker(oldId string, newWorker workers.Worker) error {
+	item := d.workers.GetById(oldId)
+	if item == nil {
+		return fmt.Errorf("Worker %s not found", oldId)
+	}
+
+	if err := d.AddWorker(newWorker); err != nil {
+		return err
+	}
+
+	workerItem := item.(*manager.WorkersManagerItem)
+	d.retireWorkerItem(workerItem)
+
+	select {
+	case <-workerItem.RetiredDone():
+		return nil
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+}
+
+// заменяет все текущие воркеры созданными factory, одновременно заменяется не больше parallelism воркеров
+func (d *SimpleDispatcher) RollingReplaceAll(factory func() workers.Worker, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		err      error
+	)
+
+	semaphore := make(chan struct{}, parallelism)
+
+	for _, w := range d.GetWorkers() {
+		semaphore <- struct{}{}
+		wg.Add(1)
+
+		go func(id string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			if e := d.ReplaceWorker(id, factory()); e != nil {
+				errMutex.Lock()
+				if err == nil {
+					err = e
+				}
+				errMutex.Unlock()
+			}
+		}(w.Id())
+	}
+
+	wg.Wait()
+	return err
+}
+
+func (d *SimpleDispatcher) retireWorker(worker workers.Worker) {
+	if item := d.workers.GetById(worker.Id()); item != nil {
+		d.retireWorkerItem(item.(*manager.WorkersManagerItem))
+	}
+}
+
+// свободный воркер удаляется сразу, занятый после завершения текущей задачи
+func (d *SimpleDispatcher) retireWorkerItem(item *manager.WorkersManagerItem) {
+	item.Retire()
+
+	if !item.IsLocked() && item.InFlight() == 0 {
+		d.removeRetiredWorker(item)
+	}
+}
+
+func (d *SimpleDispatcher) removeRetiredWorker(item *manager.WorkersManagerItem) {
+	if !item.CompleteRetire() {
+		return
+	}
+
+	d.setStatusWorker(item, workers.WorkerStatusCancel)
+	item.SetTask(nil)
+
+	d.workers.Remove(item)
+	d.failBroadcastParts(item)
+	d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerRemove, item.Worker(), item.Metadata())
+	d.stopWorker(item)
+}
+
+// выдаёт свободный воркер, попутно удаляя выведенные из пула
+func (d *SimpleDispatcher) pullWorker() workers.ManagerItem {
+	for {
+		item := d.workers.Pull()
+		if item == nil {
+			return nil
+		}
+
+		// выведенный воркер с незавершёнными задачами удалится после последней из них
+		if w := item.(*manager.WorkersManagerItem); w.IsRetired() {
+			if w.InFlight() == 0 {
+				d.removeRetiredWorker(w)
+			}
+
+			continue
+		}
+
+		return item
+	}
+}
+
+func (d *SimpleDispatcher) GetWorkerMetadata(id string) workers.Metadata {
+	if item := d.workers.GetById(id); item != nil {
+		return item.Metadata()
+	}
+
+	return nil
+}
+
+// воркер выполняет хотя бы одну задачу, для неизвестного воркера возвращается false
+func (d *SimpleDispatcher) IsWorkerBusy(id string) bool {
+	item := d.workers.GetById(id)
+	return item != nil && item.IsStatus(workers.WorkerStatusProcess)
+}
+
+func (d *SimpleDispatcher) GetWorkers() []workers.Worker {
+	all := d.workers.GetAll()
+	collection := make([]workers.Worker, 0, len(all))
+
+	for _, item := range all {
+		collection = append(collection, item.(*manager.WorkersManagerItem).Worker())
+	}
+
+	return collection
+}
+
+func (d *SimpleDispatcher) WorkerPoolSnapshot() workers.WorkerPoolSnapshot {
+	all := d.workers.GetAll()
+	snapshot := workers.WorkerPoolSnapshot{
+		CreatedAt: d.clock.Now(),
+		Workers:   make([]workers.WorkerSnapshot, 0, len(all)),
+	}
+
+	for _, item := range all {
+		workerItem := item.(*manager.WorkersManagerItem)
+		w := workers.WorkerSnapshot{
+			Id:        workerItem.Id(),
+			Status:    workerItem.Status().String(),
+			Locked:    workerItem.IsLocked(),
+			CreatedAt: workerItem.Worker().CreatedAt(),
+		}
+
+		if task := workerItem.Task(); task != nil {
+			w.Task = task.Id()
+		}
+
+		snapshot.Workers = append(snapshot.Workers, w)
+	}
+
+	sort.Slice(snapshot.Workers, func(i, j int) bool {
+		return snapshot.Workers[i].Id < snapshot.Workers[j].Id
+	})
+
+	return snapshot
+}
+
+func (d *SimpleDispatcher) StateSnapshot() workers.DispatcherSnapshot {
+	var tasks []workers.ManagerItem
+	if m, ok := d.tasks.(*manager.TasksManager); ok {
+		tasks = m.GetAllWithPulled()
+	} else {
+		tasks = d.tasks.GetAll()
+	}
+
+	pool := d.WorkerPoolSnapshot()
+	snapshot := workers.DispatcherSnapshot{
+		CreatedAt: pool.CreatedAt,
+		Status:    d.Status().String(),
+		Tasks:     make([]workers.TaskSnapshot, 0, len(tasks)),
+		Workers:   pool.Workers,
+	}
+
+	for _, item := range tasks {
+		taskItem := item.(*manager.TasksManagerItem)
+
+		snapshot.Tasks = append(snapshot.Tasks, workers.TaskSnapshot{
+			Id:             taskItem.Id(),
+			Name:           taskItem.Task().Name(),
+			Status:         taskItem.Status().String(),
+			Attempts:       taskItem.Attempts(),
+			FirstStartedAt: taskItem.FirstStartedAt(),
+			LastStartedAt:  taskItem.LastStartedAt(),
+			AllowStartAt:   taskItem.AllowStartAt(),
+		})
+	}
+
+	sort.Slice(snapshot.Tasks, func(i, j int) bool {
+		return snapshot.Tasks[i].Id < snapshot.Tasks[j].Id
+	})
+
+	return snapshot
+}
+
+// идентификаторы задач должны быть уникальны среди незавершённых задач диспетчера, задача с уже занятым Id
+// отклоняется с ErrDuplicateTaskId, а при включённой дедупликации с ErrTaskAlreadyQueued
+func (d *SimpleDispatcher) AddTask(task workers.Task) error {
+	return d.AddTaskWithMetadata(task, nil)
+}
+
+// добавляет задачу с произвольными данными для отображения и корреляции, они доступны
+// в метаданных задачи по ключу TaskMetadataCustom и не пересекаются со служебными ключами
+func (d *SimpleDispatcher) AddTaskWithMetadata(task workers.Task, metadata workers.Metadata) error {
+	if _, err := d.addTask(task, metadata); err != nil {
+		return err
+	}
+
+	d.notifyAllowExecuteTasks()
+	return nil
+}
+
+// добавляет задачу, время жизни которой ограничено ctx, например, входящим HTTP запросом: при отмене ctx
+// задача отменяется и удаляется так же, как через CancelTaskById, в том числе во время выполнения
+func (d *SimpleDispatcher) AddTaskWithContext(ctx context.Context, task workers.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item, err := d.addTask(task, nil)
+	if err != nil {
+		return err
+	}
+
+	// задачу с тем же Id могли добавить заново, поэтому отменяется только этот элемент, если он ещё в диспетчере
+	stop := context.AfterFunc(ctx, func() {
+		if existing := d.tasks.GetById(item.Id()); existing == workers.ManagerItem(item) {
+			d.cancelTask(item)
+		}
+	})
+
+	// завершённая задача больше не держит подписку на контекст, в том числе если успела завершиться до сохранения
+	d.contextWatches.Store(item, stop)
+	if item.IsRemoved() {
+		d.releaseContextWatch(item)
+	}
+
+	d.notifyAllowExecuteTasks()
+	return nil
+}
+
+// добавляет пакет задач с однократным пробуждением цикла распределения, задачи, которые не удалось
+// добавить, перечисляются в *workers.TasksError, остальные при этом остаются в очереди
+func (d *SimpleDispatcher) AddTasks(tasks ...workers.Task) error {
+	var (
+		failed map[string]error
+		added  bool
+	)
+
+	for _, task := range tasks {
+		if _, err := d.addTask(task, nil); err != nil {
+			if failed == nil {
+				failed = map[string]error{}
+			}
+
+			failed[task.Id()] = err
+			continue
+		}
+
+		added = true
+	}
+
+	if added {
+		d.notifyAllowExecuteTasks()
+	}
+
+	if failed != nil {
+		return &workers.TasksError{Errors: failed}
+	}
+
+	return nil
+}
+
+func (d *SimpleDispatcher) addTask(task workers.Task, metadata workers.Metadata) (*manager.TasksManagerItem, error) {
+	return d.addTaskWithPrepare(task, metadata, nil)
+}
+
+// prepare вызывается до помещения задачи в очередь, пока её ещё не может получить воркер
+func (d *SimpleDispatcher) addTaskWithPrepare(task workers.Task, metadata workers.Metadata, prepare func(*manager.TasksManagerItem)) (*manager.TasksManagerItem, error) {
+	d.addMutex.Lock()
+	defer d.addMutex.Unlock()
+
+	// завершившаяся задача, которую ещё не успели удалить, повторному добавлению не мешает
+	if existing := d.tasks.GetById(task.Id()); existing != nil {
+		switch existing.(*manager.TasksManagerItem).Status() {
+		case workers.TaskStatusSuccess, workers.TaskStatusFail, workers.TaskStatusFailByTimeout, workers.TaskStatusCancel, workers.TaskStatusNoWorker:
+		default:
+			if d.Deduplication() {
+				return nil, ErrTaskAlreadyQueued
+			}
+
+			return nil, ErrDuplicateTaskId
+		}
+	}
+
+	item := manager.NewTasksManagerItemWithClock(task, workers.TaskStatusWait, d.clock)
+	item.SetCustomMetadata(metadata)
+
+	if t, ok := task.(workers.TaskWithStartDelay); ok && t.StartDelay() > 0 {
+		if startAt := d.clock.Now().Add(t.StartDelay()); startAt.After(*item.AllowStartAt()) {
+			item.SetAllowStartAt(startAt)
+		}
+	}
+
+	if prepare != nil {
+		prepare(item)
+	}
+
+	err := d.tasks.Push(item)
+	if err != nil {
+		return nil, err
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskAdd, task, item.Metadata())
+	return item, nil
+}
+
+func (d *SimpleDispatcher) RemoveTask(task workers.Task) {
+	d.CancelTaskById(task.Id())
+}
+
+// отменяет и удаляет задачу по идентификатору, контекст выполняющейся задачи отменяется,
+// возвращает false если задача не найдена
+func (d *SimpleDispatcher) CancelTaskById(id string) bool {
+	item := d.tasks.GetById(id)
+	if item == nil {
+		return false
+	}
+
+	d.cancelTask(item.(*manager.TasksManagerItem))
+	return true
+}
+
+// задачи с меткой key, равной value, включая выполняющиеся, см. TaskWithTags
+func (d *SimpleDispatcher) GetTasksByTag(key, value string) []workers.Task {
+	items := d.tasksByTag(key, value)
+	collection := make([]workers.Task, 0, len(items))
+
+	for _, item := range items {
+		collection = append(collection, item.Task())
+	}
+
+	return collection
+}
+
+// отменяет и удаляет задачи с меткой key, равной value, так же, как CancelTaskById, возвращает их количество
+func (d *SimpleDispatcher) CancelTasksByTag(key, value string) int {
+	items := d.tasksByTag(key, value)
+
+	for _, item := range items {
+		d.cancelTask(item)
+	}
+
+	return len(items)
+}
+
+func (d *SimpleDispatcher) tasksByTag(key, value string) []*manager.TasksManagerItem {
+	var items []workers.ManagerItem
+
+	if m, ok := d.tasks.(*manager.TasksManager); ok {
+		items = m.GetByTag(key, value)
+	} else {
+		for _, item := range d.tasks.GetAll() {
+			if t, ok := item.(*manager.TasksManagerItem).Task().(workers.TaskWithTags); ok {
+				if v, ok := t.Tags()[key]; ok && v == value {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+
+	collection := make([]*manager.TasksManagerItem, 0, len(items))
+	for _, item := range items {
+		collection = append(collection, item.(*manager.TasksManagerItem))
+	}
+
+	return collection
+}
+
+func (d *SimpleDispatcher) cancelTask(taskItem *manager.TasksManagerItem) {
+	d.setStatusTask(taskItem, workers.TaskStatusCancel)
+	taskItem.Cancel()
+	d.cancelBroadcast(taskItem)
+
+	d.tasks.Remove(taskItem)
+	d.releaseContextWatch(taskItem)
+	d.collectStatusDurations(taskItem)
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, taskItem.Task(), taskItem.Metadata())
+	d.notifyStateChanged()
+}
+
+func (d *SimpleDispatcher) GetTaskMetadata(id string) workers.Metadata {
+	if item := d.tasks.GetById(id); item != nil {
+		return item.Metadata()
+	}
+
+	return nil
+}
+
+// то же, что GetTaskMetadata, но в виде структуры, false если задача не найдена
+func (d *SimpleDispatcher) GetTaskInfo(id string) (workers.TaskInfo, bool) {
+	if item := d.tasks.GetById(id); item != nil {
+		return workers.NewTaskInfo(item.Metadata()), true
+	}
+
+	return workers.TaskInfo{}, false
+}
+
+// задача выполняется в данный момент, для неизвестной задачи возвращается false
+func (d *SimpleDispatcher) IsTaskRunning(id string) bool {
+	item := d.tasks.GetById(id)
+	return item != nil && item.IsStatus(workers.TaskStatusProcess)
+}
+
+// блокирует до перехода задачи в указанный статус, ошибка возвращается если задача не найдена,
+// удалена или завершилась другим конечным статусом, а также при отмене ctx
+func (d *SimpleDispatcher) WaitTaskStatus(ctx context.Context, id string, status workers.TaskStatus) error {
+	reached := make(chan error, 1)
+	notify := func(err error) {
+		select {
+		case reached <- err:
+		default:
+		}
+	}
+
+	l := listener.NewFunctionListener(func(_ context.Context, event workers.Event, _ time.Time, args ...interface{}) {
+		if t, ok := args[0].(workers.Task); !ok || t.Id() != id {
+			return
+		}
+
+		if event == workers.EventTaskRemove {
+			if args[1].(workers.Metadata)[workers.TaskMetadataStatus] == status {
+				notify(nil)
+			} else {
+				notify(fmt.Errorf("Task %s was removed", id))
+			}
+
+			return
+		}
+
+		// последнее выполнение удаляет задачу без EventTaskRemove, конечный статус в итоге выполнения
+		// означает, что других переходов уже не будет
+		if event == workers.EventTaskExecuteStop {
+			metadata := args[1].(workers.Metadata)
+
+			switch current := metadata[workers.TaskMetadataStatus]; current {
+			case workers.TaskStatusSuccess, workers.TaskStatusFail, workers.TaskStatusFailByTimeout, workers.TaskStatusCancel, workers.TaskStatusNoWorker:
+				// события доставляются асинхронно, промежуточный статус мог быть пройден до итога
+				_, passed := metadata[workers.TaskMetadataStatusDurations].(map[workers.TaskStatus]time.Duration)[status]
+				if current == status || passed {
+					notify(nil)
+				} else {
+					notify(fmt.Errorf("Task %s reached terminal status %s", id, current))
+				}
+			}
+
+			return
+		}
+
+		switch current := args[2]; current {
+		case status:
+			notify(nil)
+		case workers.TaskStatusCancel, workers.TaskStatusNoWorker:
+			notify(fmt.Errorf("Task %s reached terminal status %s", id, current))
+		}
+	})
+
+	// подписка раньше проверки текущего статуса, чтобы не пропустить переход между ними
+	events := []workers.Event{workers.EventTaskStatusChanged, workers.EventTaskExecuteStop, workers.EventTaskRemove}
+	for _, event := range events {
+		d.listeners.AttachRequired(event, l)
+	}
+	defer func() {
+		for _, event := range events {
+			d.listeners.DeAttach(event, l)
+		}
+	}()
+
+	metadata := d.GetTaskMetadata(id)
+	if metadata == nil {
+		return fmt.Errorf("Task %s not found", id)
+	}
+
+	if metadata[workers.TaskMetadataStatus] == status {
+		return nil
+	}
+
+	select {
+	case err := <-reached:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type executeTaskResult struct {
+	result interface{}
+	err    error
+}
+
+// добавляет задачу и блокирует до завершения её последнего выполнения, возвращая результат RunTask.
+// Построено на обычных событиях EventTaskExecuteStop, EventTaskCacheHit и EventTaskRemove, таймаут и повторы задачи
+// учитываются как при AddTask. При отмене ctx ожидание прекращается, но задача остаётся в диспетчере
+func (d *SimpleDispatcher) ExecuteTask(ctx context.Context, task workers.Task) (interface{}, error) {
+	done := make(chan executeTaskResult, 1)
+	notify := func(r executeTaskResult) {
+		select {
+		case done <- r:
+		default:
+		}
+	}
+
+	id := task.Id()
+	l := listener.NewFunctionListener(func(_ context.Context, event workers.Event, _ time.Time, args ...interface{}) {
+		if t, ok := args[0].(workers.Task); !ok || t.Id() != id {
+			return
+		}
+
+		if event == workers.EventTaskRemove {
+			notify(executeTaskResult{err: fmt.Errorf("Task %s was removed", id)})
+			return
+		}
+
+		// задача запланирована повторно, ждём следующего выполнения
+		if args[1].(workers.Metadata)[workers.TaskMetadataStatus] == workers.TaskStatusRepeatWait {
+			return
+		}
+
+		if event == workers.EventTaskCacheHit {
+			notify(executeTaskResult{result: args[2]})
+			return
+		}
+
+		err, _ := args[5].(error)
+		notify(executeTaskResult{result: args[4], err: err})
+	})
+
+	events := []workers.Event{workers.EventTaskExecuteStop, workers.EventTaskCacheHit, workers.EventTaskRemove}
+	for _, event := range events {
+		d.listeners.AttachRequired(event, l)
+	}
+	defer func() {
+		for _, event := range events {
+			d.listeners.DeAttach(event, l)
+		}
+	}()
+
+	if err := d.AddTask(task); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-done:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *SimpleDispatcher) GetTasks() []workers.Task {
+	all := d.tasks.GetAll()
+	collection := make([]workers.Task, 0, len(all))
+
+	for _, item := range all {
+		collection = append(collection, item.(*manager.TasksManagerItem).Task())
+	}
+
+	return collection
+}
+
+// обходит задачи так же, как GetTasks, но без копирования в срез, обход прекращается, когда fn возвращает false.
+// fn вызывается под блокировкой менеджера задач и не должна обращаться к диспетчеру, иначе возможна взаимная блокировка
+func (d *SimpleDispatcher) ForEachTask(fn func(workers.Task, workers.Metadata) bool) {
+	each := func(item workers.ManagerItem) bool {
+		taskItem := item.(*manager.TasksManagerItem)
+		return fn(taskItem.Task(), taskItem.Metadata())
+	}
+
+	if m, ok := d.tasks.(*manager.TasksManager); ok {
+		m.ForEach(each)
+		return
+	}
+
+	for _, item := range d.tasks.GetAll() {
+		if !each(item) {
+			return
+		}
+	}
+}
+
+// обходит воркеры аналогично ForEachTask, с теми же ограничениями для fn
+func (d *SimpleDispatcher) ForEachWorker(fn func(workers.Worker, workers.Metadata) bool) {
+	each := func(item workers.ManagerItem) bool {
+		workerItem := item.(*manager.WorkersManagerItem)
+		return fn(workerItem.Worker(), workerItem.Metadata())
+	}
+
+	if m, ok := d.workers.(*manager.WorkersManager); ok {
+		m.ForEach(each)
+		return
+	}
+
+	for _, item := range d.workers.GetAll() {
+		if !each(item) {
+			return
+		}
+	}
+}
+
+// сводка по состоянию диспетчера: задачи по статусам с учётом выполняющихся, воркеры по статусам,
+// количество выполнений в процессе и задач с отложенным запуском
+func (d *SimpleDispatcher) Stats() workers.DispatcherStats {
+	now := d.clock.Now()
+	stats := workers.DispatcherStats{
+		CreatedAt: now,
+		Tasks:     map[workers.TaskStatus]int{},
+		Workers:   map[workers.WorkerStatus]int{},
+		InFlight:  atomic.LoadInt64(&d.runningTasks),
+	}
+
+	var tasks []workers.ManagerItem
+	if m, ok := d.tasks.(*manager.TasksManager); ok {
+		tasks = m.GetAllWithPulled()
+	} else {
+		tasks = d.tasks.GetAll()
+	}
+
+	for _, item := range tasks {
+		taskItem := item.(*manager.TasksManagerItem)
+		stats.Tasks[workers.TaskStatus(taskItem.StatusInt64())]++
+
+		if allowStartAt := taskItem.AllowStartAt(); allowStartAt != nil && allowStartAt.After(now) {
+			stats.Delayed++
+		}
+	}
+
+	for _, item := range d.workers.GetAll() {
+		stats.Workers[workers.WorkerStatus(item.(*manager.WorkersManagerItem).StatusInt64())]++
+	}
+
+	return stats
+}
+
+func (d *SimpleDispatcher) SetTenantWeight(tenant string, weight int) {
+	if m, ok := d.tasks.(*manager.TasksManager); ok {
+		m.SetTenantWeight(tenant, weight)
+	}
+}
+
+// возвращаемая функция отписывает слушателя от события так же, как RemoveListener,
+// повторные вызовы ничего не делают, поэтому её удобно откладывать через defer
+func (d *SimpleDispatcher) AddListener(eventId workers.Event, listener workers.Listener) (func(), error) {
+	d.listeners.Attach(eventId, listener)
+	d.listeners.AsyncTrigger(d.Context(), workers.EventListenerAdd, eventId, listener, d.GetListenerMetadata(listener.Id()))
+
+	return d.listenerDetach(eventId, listener), nil
+}
+
+// слушатели с большим приоритетом вызываются раньше, порядок гарантируется только для синхронных вызовов одного события
+func (d *SimpleDispatcher) AddListenerWithPriority(eventId workers.Event, listener workers.Listener, priority int) (func(), error) {
+	d.listeners.AttachWithPriority(eventId, listener, priority)
+	d.listeners.AsyncTrigger(d.Context(), workers.EventListenerAdd, eventId, listener, d.GetListenerMetadata(listener.Id()))
+
+	return d.listenerDetach(eventId, listener), nil
+}
+
+func (d *SimpleDispatcher) listenerDetach(eventId workers.Event, listener workers.Listener) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			d.RemoveListener(eventId, listener)
+		})
+	}
+}
+
+func (d *SimpleDispatcher) RemoveListener(eventId workers.Event, listener workers.Listener) {
+	item := d.listeners.GetById(listener.Id())
+	if item != nil {
+		d.listeners.DeAttach(eventId, listener)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventListenerRemove, eventId, listener, item.Metadata())
+	}
+}
+
+// отписывает от события всех слушателей, для каждого срабатывает EventListenerRemove
+func (d *SimpleDispatcher) RemoveAllListeners(eventId workers.Event) {
+	for _, item := range d.listeners.RemoveAllListeners(eventId) {
+		d.listeners.AsyncTrigger(d.Context(), workers.EventListenerRemove, eventId, item.Listener(), item.Metadata())
+	}
+}
+
+// отписывает всех слушателей от всех событий, EventListenerRemove срабатывает для каждой подписки,
+// но его собственные слушатели к этому моменту тоже отписаны
+func (d *SimpleDispatcher) ClearListeners() {
+	for eventId, items := range d.listeners.ClearListeners() {
+		for _, item := range items {
+			d.listeners.AsyncTrigger(d.Context(), workers.EventListenerRemove, eventId, item.Listener(), item.Metadata())
+		}
+	}
+}
+
+func (d *SimpleDispatcher) GetListenerMetadata(id string) workers.Metadata {
+	if item := d.listeners.GetById(id); item != nil {
+		return item.Metadata()
+	}
+
+	return nil
+}
+
+func (d *SimpleDispatcher) GetListeners() []workers.Listener {
+	return d.listeners.Listeners()
+}
+
+func (d *SimpleDispatcher) ListenerSubscriptions() map[string][]workers.Event {
+	return d.listeners.Subscriptions()
+}
+
+func (d *SimpleDispatcher) SetListenersBatchWindow(window time.Duration) {
+	d.listeners.SetBatchWindow(window)
+}
+
+// ограничивает количество одновременно выполняемых асинхронных вызовов слушателей, 0 снимает ограничение.
+// Обработчики запускаются вместе с диспетчером и останавливаются при его остановке
+func (d *SimpleDispatcher) SetListenersAsyncConcurrency(n int) {
+	d.listeners.SetAsyncConcurrency(n)
+}
+
+// количество событий, не доставленных слушателям из-за переполнения очереди асинхронных вызовов
+// или буфера каналов событий
+func (d *SimpleDispatcher) DroppedListenerEvents() int64 {
+	return d.listeners.DroppedEvents()
+}
+
+// поведение каналов событий, читатель которых отстаёт: по умолчанию события сверх буфера
+// в defaultEventsBufferSize отбрасываются. Действует на подписки, созданные после вызова
+func (d *SimpleDispatcher) SetEventsOverflow(policy manager.EventsOverflowPolicy, size int) {
+	d.eventsOverflow.Store(eventsOverflowHolder{policy: policy, size: size})
+}
+
+// канал всех событий диспетчера для чтения в отдельной горутине, каждый вызов создаёт новую подписку,
+// канал закрывается после остановки диспетчера, когда доставлены события завершения задач
+func (d *SimpleDispatcher) Events() <-chan workers.EventData {
+	events, _ := d.SubscribeEvents()
+	return events
+}
+
+// то же, что и Events, но подписку можно отменить раньше остановки диспетчера, канал при этом закрывается
+func (d *SimpleDispatcher) SubscribeEvents() (<-chan workers.EventData, func()) {
+	h, ok := d.eventsOverflow.Load().(eventsOverflowHolder)
+	if !ok {
+		h = eventsOverflowHolder{policy: manager.EventsOverflowDrop, size: defaultEventsBufferSize}
+	}
+
+	subscription := d.listeners.Subscribe(h.policy, h.size)
+
+	go func() {
+		select {
+		case <-d.ctx.Done():
+			// запущенный диспетчер ещё сообщит об отмене выполнявшихся задач
+			if !d.IsStatus(workers.DispatcherStatusWait) {
+				<-d.stopped
+			}
+
+			subscription.Close()
+
+		case <-subscription.Done():
+		}
+	}()
+
+	return subscription.C(), subscription.Cancel
+}
+
+func (d *SimpleDispatcher) doResultCollector() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case result := <-d.results:
+			d.doResult(result)
+
+		case <-d.ctx.Done():
+			// результаты, отправленные до остановки, обрабатываем здесь, чтобы не оставлять отправителей заблокированными
+			for {
+				select {
+				case result := <-d.results:
+					d.doResult(result)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *SimpleDispatcher) doResult(result SimpleDispatcherResult) {
+	// выполнение считается завершённым только после того, как задача заново запланирована или удалена
+	defer func() {
+		running := atomic.AddInt64(&d.runningTasks, -1)
+		if running == 0 {
+			select {
+			case d.idle <- struct{}{}:
+			default:
+			}
+		}
+
+		// освободилось место под ограничением одновременных выполнений
+		if limit := d.MaxConcurrent(); limit > 0 && running < int64(limit) {
+			d.notifyAllowExecuteTasks()
+		}
+
+		d.notifyStateChanged()
+	}()
+
+	result.taskItem.SetCancel(nil)
+	result.workerItem.SetTaskCancel(result.taskItem.Id(), nil)
+	remaining := result.workerItem.Release()
+
+	// удалённый воркер, в том числе при остановке диспетчера, освобождает ресурсы после последней задачи
+	if remaining == 0 && (result.workerItem.IsStatus(workers.WorkerStatusCancel) || result.workerItem.IsCanceled()) {
+		d.stopWorker(result.workerItem)
+	}
+
+	// во время остановки диспетчера фиксируем итог выполнения, но повторно задачу не планируем
+	if d.ctx.Err() != nil {
+		d.doResultOnCancel(result)
+		return
+	}
+
+	if remaining == 0 {
+		result.workerItem.SetTask(nil)
+	}
+
+	if result.workerItem.IsRetired() {
+		if remaining == 0 {
+			d.removeRetiredWorker(result.workerItem)
+		}
+	} else if !result.workerItem.IsCanceled() && (!result.cancel || !result.workerItem.IsStatus(workers.WorkerStatusCancel)) {
+		if remaining == 0 {
+			d.setStatusWorker(result.workerItem, workers.WorkerStatusWait)
+		}
+
+		// насыщенный воркер был изъят из очереди, возвращаем его, как только появилось место
+		if remaining+1 >= int64(result.workerItem.Capacity()) {
+			if err := d.workers.Push(result.workerItem); err != nil {
+				d.Logger().Error("Push worker failed", "worker", result.workerItem.Id(), "error", err)
+			}
+		}
+	}
+
+	if part, ok := result.taskItem.Task().(*broadcastPart); ok {
+		d.doBroadcastPartResult(part, result)
+		d.notifyAllowExecuteTasks()
+		return
+	}
+
+	d.recordExecution(result)
+
+	if result.cancel {
+		d.refundAttempt(result.taskItem)
+	}
+
+	// задача удалена во время выполнения, её результат отбрасывается
+	if result.taskItem.IsRemoved() {
+		d.notifyAllowExecuteTasks()
+		return
+	}
+
+	if !result.cancel && !result.taskItem.IsStatus(workers.TaskStatusCancel) {
+		if result.err != nil {
+			d.countError(result.err)
+			d.setStatusTask(result.taskItem, failStatus(result.err))
+		} else {
+			d.setStatusTask(result.taskItem, workers.TaskStatusSuccess)
+			d.cacheResult(result.taskItem.Task(), result.result)
+		}
+
+		d.recordCircuitResult(result.err != nil)
+
+		d.scheduleNextRun(result.taskItem, result.err)
+		if result.taskItem.IsRemoved() {
+			d.retainResult(result.taskItem, result.result, result.err)
+		}
+	} else if result.cancel {
+		// выполнение прервано удалением воркера, в очередь задача не возвращается, а завершается отменой
+		d.setStatusTask(result.taskItem, workers.TaskStatusCancel)
+		d.removeFinishedTask(result.taskItem)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, result.taskItem.Task(), result.taskItem.Metadata())
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, result.taskItem.Task(), result.taskItem.Metadata(), result.workerItem.Worker(), result.workerItem.Metadata(), result.result, result.err, result.cancel, result.executed)
+	d.notifyAllowExecuteTasks()
+}
+
+// планирует следующий запуск задачи, если повторы не исчерпаны, иначе удаляет её
+func (d *SimpleDispatcher) scheduleNextRun(taskItem *manager.TasksManagerItem, lastErr error) {
+	if !retryable(lastErr) {
+		d.removeFinishedTask(taskItem)
+		return
+	}
+
+	if repeats := taskItem.Task().Repeats(); repeats >= 0 && taskItem.Attempts() >= repeats {
+		d.removeFinishedTask(taskItem)
+		return
+	}
+
+	// успешные запуски повторяющихся задач пределом не ограничиваются
+	if limit := d.MaxAttempts(); limit > 0 && lastErr != nil && taskItem.Attempts() >= limit {
+		d.removeFinishedTask(taskItem)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskDeadLetter, taskItem.Task(), taskItem.Metadata(), lastErr)
+
+		if handler := d.DeadLetterHandler(); handler != nil {
+			handler(taskItem.Task(), lastErr)
+		}
+
+		return
+	}
+
+	reason := workers.TaskRescheduleReasonImmediate
+	repeatInterval := taskItem.Task().RepeatInterval()
+	if repeatInterval > 0 {
+		reason = workers.TaskRescheduleReasonInterval
+	}
+
+	switch t := taskItem.Task().(type) {
+	case workers.TaskWithSchedule:
+		next, ok := t.NextRunAt(d.clock.Now())
+		if !ok {
+			d.removeFinishedTask(taskItem)
+			return
+		}
+
+		reason = workers.TaskRescheduleReasonSchedule
+		repeatInterval = 0
+		taskItem.SetAllowStartAt(next)
+
+	case workers.TaskWithBackoff:
+		reason = workers.TaskRescheduleReasonBackoff
+		repeatInterval = t.BackoffInterval(taskItem.Attempts(), lastErr)
+	}
+
+	if repeatInterval > 0 {
+		taskItem.SetAllowStartAt(d.clock.Now().Add(repeatInterval))
+	}
+
+	taskItem.SetReadySince(d.clock.Now())
+	d.setStatusTask(taskItem, workers.TaskStatusRepeatWait)
+	if err := d.tasks.Push(taskItem); err != nil {
+		d.Logger().Error("Push task failed", "task", taskItem.Id(), "error", err)
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskReschedule, taskItem.Task(), taskItem.Metadata(), *taskItem.AllowStartAt(), reason)
+
+	// повтор после ошибки отдельным событием, чтобы по нему можно было строить оповещения
+	if lastErr != nil {
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRetry, taskItem.Task(), taskItem.Metadata(), taskItem.Attempts(), *taskItem.AllowStartAt(), lastErr)
+	}
+}
+
+// записывает выполнение в историю задачи до того, как отменённому выполнению вернут попытку
+func (d *SimpleDispatcher) recordExecution(result SimpleDispatcherResult) {
+	limit := d.HistorySize()
+	if limit <= 0 {
+		return
+	}
+
+	status := workers.TaskStatusSuccess
+	switch {
+	case result.cancel || (!result.executed && result.err == nil):
+		status = workers.TaskStatusCancel
+	case result.err != nil:
+		status = failStatus(result.err)
+	}
+
+	record := workers.ExecutionRecord{
+		Attempt:  result.taskItem.Attempts(),
+		WorkerId: result.workerItem.Id(),
+		Status:   status,
+		Err:      result.err,
+	}
+
+	if startedAt := result.taskItem.LastStartedAt(); startedAt != nil {
+		record.StartedAt = *startedAt
+		record.Duration = d.clock.Since(*startedAt)
+	}
+
+	result.taskItem.AddExecutionRecord(record, limit)
+}
+
+// отменённое выполнение не расходует попытку, засчитанную при запуске
+func (d *SimpleDispatcher) refundAttempt(taskItem *manager.TasksManagerItem) {
+	if attempts := taskItem.Attempts(); attempts > 0 {
+		taskItem.SetAttempts(attempts - 1)
+	}
+}
+
+func (d *SimpleDispatcher) removeFinishedTask(taskItem *manager.TasksManagerItem) {
+	d.tasks.Remove(taskItem)
+	d.broadcasts.Delete(taskItem)
+	d.releaseContextWatch(taskItem)
+	d.collectStatusDurations(taskItem)
+}
+
+func (d *SimpleDispatcher) releaseContextWatch(taskItem *manager.TasksManagerItem) {
+	if stop, ok := d.contextWatches.LoadAndDelete(taskItem); ok {
+		stop.(func() bool)()
+	}
+}
+
+func (d *SimpleDispatcher) doResultOnCancel(result SimpleDispatcherResult) {
+	result.workerItem.SetTask(nil)
+
+	if part, ok := result.taskItem.Task().(*broadcastPart); ok {
+		d.doBroadcastPartResult(part, result)
+		return
+	}
+
+	d.recordExecution(result)
+
+	if result.cancel {
+		d.refundAttempt(result.taskItem)
+	}
+
+	if result.taskItem.IsRemoved() {
+		return
+	}
+
+	// успех фиксируется только по возврату RunTask
+	switch {
+	case result.cancel || (!result.executed && result.err == nil):
+		d.setStatusTask(result.taskItem, workers.TaskStatusCancel)
+	case result.err != nil:
+		d.countError(result.err)
+		d.setStatusTask(result.taskItem, failStatus(result.err))
+	default:
+		d.setStatusTask(result.taskItem, workers.TaskStatusSuccess)
+	}
+
+	d.collectStatusDurations(result.taskItem)
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, result.taskItem.Task(), result.taskItem.Metadata(), result.workerItem.Worker(), result.workerItem.Metadata(), result.result, result.err, true, result.executed)
+}
+
+func (d *SimpleDispatcher) countError(err error) {
+	category := workers.ErrorCategoryUnknown
+
+	// категория учитывается и у обёрнутой ошибки, как и признак повтора в retryable
+	var e workers.ErrorWithCategory
+	if errors.As(err, &e) {
+		category = e.Category()
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		category = workers.ErrorCategoryTimeout
+	}
+
+	d.statsMutex.Lock()
+	d.errorsCategories[category]++
+	d.statsMutex.Unlock()
+}
+
+// выполнение, прерванное по истечении таймаута или срока задачи, отличается от прочих отказов
+func failStatus(err error) workers.TaskStatus {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return workers.TaskStatusFailByTimeout
+	}
+
+	return workers.TaskStatusFail
+}
+
+// повторять нельзя только после ошибки, явно сообщившей об этом, в том числе обёрнутой
+func retryable(err error) bool {
+	var e workers.ErrorWithRetryable
+	if err != nil && errors.As(err, &e) {
+		return e.Retryable()
+	}
+
+	return true
+}
+
+func (d *SimpleDispatcher) collectStatusDurations(item *manager.TasksManagerItem) {
+	durations := item.StatusDurations()
+
+	d.statsMutex.Lock()
+	for status, duration := range durations {
+		d.tasksStatusDurations[status] += duration
+	}
+	d.statsMutex.Unlock()
+}
+
+// блокирует, пока нет выполняющихся задач и задач, готовых к запуску, задачи с отложенным
+// запуском не учитываются, можно вызывать из нескольких горутин одновременно
+func (d *SimpleDispatcher) WaitIdle(ctx context.Context) error {
+	for {
+		changed := d.stateChangedChan()
+
+		if d.isIdle() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *SimpleDispatcher) isIdle() bool {
+	if atomic.LoadInt64(&d.runningTasks) > 0 || atomic.LoadUint32(&d.dispatching) == 1 {
+		return false
+	}
+
+	now := d.clock.Now()
+
+	for _, item := range d.tasks.GetAll() {
+		if !item.(*manager.TasksManagerItem).AllowStartAt().After(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *SimpleDispatcher) stateChangedChan() <-chan struct{} {
+	d.stateMutex.Lock()
+	defer d.stateMutex.Unlock()
+
+	return d.stateChanged
+}
+
+// будит всех ожидающих в WaitIdle для повторной проверки
+func (d *SimpleDispatcher) notifyStateChanged() {
+	d.stateMutex.Lock()
+	defer d.stateMutex.Unlock()
+
+	close(d.stateChanged)
+	d.stateChanged = make(chan struct{})
+}
+
+// объясняет, почему не запускаются новые задачи, пустая строка означает, что в очереди есть задачи,
+// готовые к запуску на свободном воркере
+func (d *SimpleDispatcher) IdleReason() string {
+	switch {
+	case d.IsStatus(workers.DispatcherStatusPause):
+		return "paused"
+	case d.IsStatus(workers.DispatcherStatusShutdown):
+		return "shutting down"
+	case !d.IsStatus(workers.DispatcherStatusProcess):
+		return "dispatcher isn't running"
+	}
+
+	tasks := d.tasks.GetAll()
+	if len(tasks) == 0 {
+		return "no tasks queued"
+	}
+
+	var next *time.Time
+	now := d.clock.Now()
+
+	for _, item := range tasks {
+		allowStartAt := item.(*manager.TasksManagerItem).AllowStartAt()
+		if !allowStartAt.After(now) {
+			next = nil
+			break
+		}
+
+		if next == nil || allowStartAt.Before(*next) {
+			next = allowStartAt
+		}
+	}
+
+	if next != nil {
+		return "all tasks scheduled for the future (next at " + next.Format(time.RFC3339) + ")"
+	}
+
+	for _, item := range d.workers.GetAll() {
+		if !item.IsLocked() && !item.(*manager.WorkersManagerItem).IsRetired() {
+			return ""
+		}
+	}
+
+	return "no idle workers"
+}
+
+// профайлер вызывается синхронно из цикла распределения задач, поэтому должен быть дешёвым, nil отключает профилирование
+func (d *SimpleDispatcher) SetProfiler(profiler workers.Profiler) {
+	d.profiler.Store(profilerHolder{profiler: profiler})
+}
+
+func (d *SimpleDispatcher) Profiler() workers.Profiler {
+	if h, ok := d.profiler.Load().(profilerHolder); ok {
+		return h.profiler
+	}
+
+	return nil
+}
+
+// дополняет контекст каждой задачи перед запуском, например, значениями трассировки или авторизации,
+// применяется до ограничения таймаутом, nil отключает
+func (d *SimpleDispatcher) SetContextDecorator(decorator func(context.Context, workers.Task) context.Context) {
+	d.contextDecorator.Store(contextDecoratorHolder{decorator: decorator})
+}
+
+func (d *SimpleDispatcher) ContextDecorator() func(context.Context, workers.Task) context.Context {
+	if h, ok := d.contextDecorator.Load().(contextDecoratorHolder); ok {
+		return h.decorator
+	}
+
+	return nil
+}
+
+// общий предел попыток для всех задач, в том числе с бесконечными повторами, задача, исчерпавшая его
+// неудачным запуском, удаляется вместо повторного запуска с событием EventTaskDeadLetter,
+// успешно выполняющиеся периодические задачи продолжают работать, n <= 0 снимает ограничение
+func (d *SimpleDispatcher) SetMaxAttempts(n int64) {
+	atomic.StoreInt64(&d.maxAttempts, n)
+}
+
+func (d *SimpleDispatcher) MaxAttempts() int64 {
+	return atomic.LoadInt64(&d.maxAttempts)
+}
+
+// вызывается для задач, исчерпавших MaxAttempts, с ошибкой последней попытки, синхронно
+// с обработкой результата, поэтому не должен блокировать, nil отключает
+func (d *SimpleDispatcher) SetDeadLetterHandler(handler func(workers.Task, error)) {
+	d.deadLetterHandler.Store(deadLetterHandlerHolder{handler: handler})
+}
+
+func (d *SimpleDispatcher) DeadLetterHandler() func(workers.Task, error) {
+	if h, ok := d.deadLetterHandler.Load().(deadLetterHandlerHolder); ok {
+		return h.handler
+	}
+
+	return nil
+}
+
+// сохраняет итог последнего выполнения завершившихся задач, чтобы его можно было получить через GetTaskResult
+// после удаления задачи из диспетчера, хранится не больше size итогов не дольше ttl, size <= 0 или ttl <= 0 отключают хранение
+func (d *SimpleDispatcher) SetResultRetention(size int, ttl time.Duration) {
+	if size <= 0 || ttl <= 0 {
+		d.resultRetention.Store(resultRetentionHolder{})
+		return
+	}
+
+	d.resultRetention.Store(resultRetentionHolder{
+		cache: cache.NewLRUCache(size),
+		ttl:   ttl,
+	})
+}
+
+// итог завершившейся задачи, false если задача ещё не завершилась, итог вытеснен или хранение отключено
+func (d *SimpleDispatcher) GetTaskResult(id string) (interface{}, error, bool) {
+	h, ok := d.resultRetention.Load().(resultRetentionHolder)
+	if !ok || h.cache == nil {
+		return nil, nil, false
+	}
+
+	value, ok := h.cache.Get(id)
+	if !ok {
+		return nil, nil, false
+	}
+
+	r := value.(retainedResult)
+	return r.result, r.err, true
+}
+
+// сколько последних выполнений хранить в истории каждой задачи, включая повторы, k <= 0 отключает историю.
+// После завершения задачи история доступна, пока хранится её итог по SetResultRetention
+func (d *SimpleDispatcher) SetHistorySize(k int) {
+	if k < 0 {
+		k = 0
+	}
+
+	atomic.StoreInt64(&d.historySize, int64(k))
+}
+
+func (d *SimpleDispatcher) HistorySize() int {
+	return int(atomic.LoadInt64(&d.historySize))
+}
+
+// последние выполнения задачи от старых к новым, nil для неизвестной задачи или при отключённой истории
+func (d *SimpleDispatcher) GetTaskHistory(id string) []workers.ExecutionRecord {
+	if item := d.tasks.GetById(id); item != nil {
+		return item.(*manager.TasksManagerItem).History()
+	}
+
+	if h, ok := d.resultRetention.Load().(resultRetentionHolder); ok && h.cache != nil {
+		if value, ok := h.cache.Get(id); ok {
+			return value.(retainedResult).history
+		}
+	}
+
+	return nil
+}
+
+// повторно ставит в очередь завершённую задачу, пока её итог хранится по SetResultRetention: задача начинает
+// заново с нулевым числом попыток и без отметок о запусках, сохранённый итог остаётся доступным до нового.
+// Для задачи, итог которой уже не хранится, возвращается ErrTaskNotFound
+func (d *SimpleDispatcher) Requeue(id string) error {
+	h, ok := d.resultRetention.Load().(resultRetentionHolder)
+	if !ok || h.cache == nil {
+		return ErrTaskNotFound
+	}
+
+	value, ok := h.cache.Get(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+
+	if _, err := d.addTask(value.(retainedResult).task, nil); err != nil {
+		return err
+	}
+
+	d.notifyAllowExecuteTasks()
+	return nil
+}
+
+func (d *SimpleDispatcher) retainResult(taskItem *manager.TasksManagerItem, result interface{}, err error) {
+	if h, ok := d.resultRetention.Load().(resultRetentionHolder); ok && h.cache != nil {
+		h.cache.Set(taskItem.Id(), retainedResult{task: taskItem.Task(), result: result, err: err, history: taskItem.History()}, h.ttl)
+	}
+}
+
+// ограничивает частоту запуска задач независимо от количества воркеров, limit <= 0 снимает ограничение.
+// Пока ограничитель не пропускает, задача остаётся в очереди и не занимает воркер
+func (d *SimpleDispatcher) SetExecuteRateLimit(limit rate.Limit, burst int) {
+	if limit <= 0 {
+		d.rateLimiter.Store(rateLimiterHolder{})
+		return
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	d.rateLimiter.Store(rateLimiterHolder{limiter: rate.NewLimiter(limit, burst)})
+}
+
+func (d *SimpleDispatcher) executeRateLimiter() *rate.Limiter {
+	if h, ok := d.rateLimiter.Load().(rateLimiterHolder); ok {
+		return h.limiter
+	}
+
+	return nil
+}
+
+// резервирует разрешение на запуск, при его отсутствии возвращает время ожидания следующего
+func (d *SimpleDispatcher) reserveExecute() time.Duration {
+	limiter := d.executeRateLimiter()
+	if limiter == nil {
+		return 0
+	}
+
+	now := d.clock.Now()
+	r := limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+
+	return 0
+}
+
+// логгер внутренних ошибок диспетчера и паник слушателей, по умолчанию сообщения отбрасываются, nil возвращает умолчание
+func (d *SimpleDispatcher) SetLogger(logger workers.Logger) {
+	d.listeners.SetLogger(logger)
+}
+
+func (d *SimpleDispatcher) Logger() workers.Logger {
+	return d.listeners.Logger()
+}
+
+// хранилище результатов задач с TaskWithCache, по умолчанию в памяти, nil отключает кэширование
+func (d *SimpleDispatcher) SetResultCache(cache workers.ResultCache) {
+	d.resultCache.Store(resultCacheHolder{cache: cache})
+}
+
+func (d *SimpleDispatcher) ResultCache() workers.ResultCache {
+	if h, ok := d.resultCache.Load().(resultCacheHolder); ok {
+		return h.cache
+	}
+
+	return nil
+}
+
+func (d *SimpleDispatcher) cacheResult(task workers.Task, result interface{}) {
+	t, ok := task.(workers.TaskWithCache)
+	if !ok || t.CacheKey() == "" || t.CacheTTL() <= 0 {
+		return
+	}
+
+	if c := d.ResultCache(); c != nil {
+		c.Set(t.CacheKey(), result, t.CacheTTL())
+	}
+}
+
+func (d *SimpleDispatcher) cachedResult(task workers.Task) (interface{}, bool) {
+	t, ok := task.(workers.TaskWithCache)
+	if !ok || t.CacheKey() == "" || t.CacheTTL() <= 0 {
+		return nil, false
+	}
+
+	c := d.ResultCache()
+	if c == nil {
+		return nil, false
+	}
+
+	return c.Get(t.CacheKey())
+}
+
+// задача завершается сохранённым результатом без запуска на воркере
+func (d *SimpleDispatcher) doCacheHit(taskItem *manager.TasksManagerItem, result interface{}) {
+	taskItem.SetAttempts(taskItem.Attempts() + 1)
+	d.setStatusTask(taskItem, workers.TaskStatusSuccess)
+	d.scheduleNextRun(taskItem, nil)
+	if taskItem.IsRemoved() {
+		d.retainResult(taskItem, result, nil)
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskCacheHit, taskItem.Task(), taskItem.Metadata(), result)
+}
+
+// задача со сроком, истёкшим до запуска, на воркер не попадает и завершается с ErrTaskDeadline,
+// в событии указывается воркер, выбравший её из очереди
+func (d *SimpleDispatcher) doDeadlineExceeded(taskItem *manager.TasksManagerItem, workerItem *manager.WorkersManagerItem) {
+	d.countError(ErrTaskDeadline)
+	d.setStatusTask(taskItem, workers.TaskStatusFailByTimeout)
+	d.removeFinishedTask(taskItem)
+	d.retainResult(taskItem, nil, ErrTaskDeadline)
+	// итог сообщается событием выполнения, чтобы ожидающие его получили ошибку дедлайна
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStop, taskItem.Task(), taskItem.Metadata(), workerItem.Worker(), workerItem.Metadata(), nil, ErrTaskDeadline, false, false)
+	d.notifyStateChanged()
+}
+
+// более ранний из сроков Timeout и TaskWithDeadline, нулевое время если ни один не задан,
+// при нулевом Timeout используется defaultTimeout
+func taskDeadline(task workers.Task, startedAt time.Time, defaultTimeout time.Duration) time.Time {
+	var deadline time.Time
+
+	timeout := task.Timeout()
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	if timeout > 0 {
+		deadline = startedAt.Add(timeout)
+	}
+
+	if t, ok := task.(workers.TaskWithDeadline); ok {
+		if d := t.Deadline(); !d.IsZero() && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+
+	return deadline
+}
+
+func (d *SimpleDispatcher) doDispatch() {
+	defer d.wg.Done()
+
+	// пробуждение к ближайшей отложенной задаче, чтобы не ждать очередного тика
+	wakeup := d.clock.NewTimer(time.Hour)
+	wakeup.Stop()
+
+	for {
+		var skipped bool
+
+		select {
+		case <-d.allowExecuteTasks:
+			skipped = d.doExecuteTasks()
+
+		case <-d.tickerAllowExecuteTasks.C():
+			skipped = d.doExecuteTasks()
+
+		case <-wakeup.C():
+			if m, ok := d.tasks.(*manager.TasksManager); ok {
+				m.Recalculate()
+			}
+
+			skipped = d.doExecuteTasks()
+
+		case <-d.ctx.Done():
+			wakeup.Stop()
+			d.tickerAllowExecuteTasks.Stop()
+			return
+		}
+
+		d.scheduleWakeup(wakeup, skipped)
+	}
+}
+
+// вызывает fn через duration по часам диспетчера, вызов отменяется остановкой диспетчера или возвращённой функцией
+func (d *SimpleDispatcher) afterFunc(duration time.Duration, fn func()) (stop func()) {
+	timer := d.clock.NewTimer(duration)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			fn()
+		case <-d.ctx.Done():
+		case <-stopped:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopped)
+		})
+	}
+}
+
+func (d *SimpleDispatcher) scheduleWakeup(wakeup clock.Timer, skipped bool) {
+	if !wakeup.Stop() {
+		select {
+		case <-wakeup.C():
+		default:
+		}
+	}
+
+	var (
+		delay time.Duration
+		armed bool
+	)
+
+	m, ok := d.tasks.(*manager.TasksManager)
+	if ok {
+		if next, ok := m.NextAllowStartAt(); ok {
+			delay, armed = next.Sub(d.clock.Now()), true
+		}
+	}
+
+	// при отключённом тикере пропущенные задачи и отложенные задачи стороннего менеджера, время запуска
+	// которых неизвестно, подхватывает страховочный проход
+	if d.TickerExecuteTasksDuration() <= 0 && (skipped || !ok) && (!armed || delay > fallbackWakeupInterval) {
+		delay, armed = fallbackWakeupInterval, true
+	}
+
+	if armed {
+		wakeup.Reset(delay)
+	}
+}
+
+// возвращает true, если часть задач осталась в очереди непринятой: закрытый гейт, отказ селектора или ограничение частоты
+func (d *SimpleDispatcher) doExecuteTasks() (hasSkipped bool) {
+	if !d.IsStatus(workers.DispatcherStatusProcess) {
+		return false
+	}
+
+	atomic.StoreUint32(&d.dispatching, 1)
+	defer func() {
+		atomic.StoreUint32(&d.dispatching, 0)
+		d.notifyStateChanged()
+	}()
+
+	profiler := d.Profiler()
+
+	// пропущенные задачи возвращаются в очередь только после выхода из цикла, чтобы не выбрать их повторно
+	var skipped []workers.ManagerItem
+	defer func() {
+		for _, t := range skipped {
+			_ = d.tasks.Push(t)
+		}
+
+		hasSkipped = len(skipped) > 0
+	}()
+
+	for {
+		if limit := d.MaxConcurrent(); limit > 0 && atomic.LoadInt64(&d.runningTasks) >= int64(limit) {
+			return
+		}
+
+		pullWorker := d.pullWorker()
+
+		// части широковещательных задач закреплены за воркерами и выдаются им в первую очередь
+		if pullWorker != nil {
+			castWorker := pullWorker.(*manager.WorkersManagerItem)
+
+			if part := d.pullBroadcastPart(castWorker); part != nil {
+				if delay := d.reserveExecute(); delay > 0 {
+					_ = d.workers.Push(castWorker)
+					d.requeueBroadcastPart(part)
+					d.afterFunc(delay, d.notifyAllowExecuteTasks)
+					return
+				}
+
+				d.executeTask(castWorker, part, profiler)
+				continue
+			}
+		}
+
+		pullTask := d.tasks.Pull()
+
+		if pullWorker != nil && pullTask != nil {
+			castWorker := pullWorker.(*manager.WorkersManagerItem)
+			castTask := pullTask.(*manager.TasksManagerItem)
+			if castTask.IsStatus(workers.TaskStatusCancel) {
+				_ = d.workers.Push(pullWorker)
+				d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, castTask.Task(), castTask.Metadata())
+				continue
+			}
+
+			if t, ok := castTask.Task().(workers.TaskWithDeadline); ok && !t.Deadline().IsZero() && !d.clock.Now().Before(t.Deadline()) {
+				_ = d.workers.Push(pullWorker)
+				d.doDeadlineExceeded(castTask, castWorker)
+				continue
+			}
+
+			if d.isBroadcast(castTask) {
+				_ = d.workers.Push(pullWorker)
+				d.startBroadcast(castTask, castWorker)
+				continue
+			}
+
+			if gate, ok := castTask.Task().(workers.TaskWithGate); ok && !gate.Gate() {
+				_ = d.workers.Push(pullWorker)
+
+				if repeatInterval := castTask.Task().RepeatInterval(); repeatInterval > 0 {
+					castTask.SetAllowStartAt(d.clock.Now().Add(repeatInterval))
+				}
+
+				skipped = append(skipped, castTask)
+				d.listeners.AsyncTrigger(d.Context(), workers.EventTaskSkipped, castTask.Task(), castTask.Metadata())
+				continue
+			}
+
+			if result, ok := d.cachedResult(castTask.Task()); ok {
+				_ = d.workers.Push(pullWorker)
+				d.doCacheHit(castTask, result)
+
+				// повторяющаяся задача сразу вернётся в очередь, поэтому продолжаем в следующем проходе
+				d.notifyAllowExecuteTasks()
+				return
+			}
+
+			if castWorker = d.selectWorker(castWorker, castTask.Task()); castWorker == nil {
+				skipped = append(skipped, castTask)
+				continue
+			}
+
+			if delay := d.reserveExecute(); delay > 0 {
+				_ = d.workers.Push(castWorker)
+				skipped = append(skipped, castTask)
+				d.afterFunc(delay, d.notifyAllowExecuteTasks)
+				return
+			}
+
+			d.executeTask(castWorker, castTask, profiler)
+		} else {
+			if pullWorker != nil {
+				_ = d.workers.Push(pullWorker)
+			}
+
+			if pullTask != nil {
+				_ = d.tasks.Push(pullTask)
+			}
+
+			if profiler != nil {
+				if pullWorker == nil {
+					profiler.OnNoWorker()
+				}
+
+				if pullTask == nil {
+					profiler.OnNoTask()
+				}
+			}
+
+			if pullWorker == nil {
+				d.failWorkerWaitTasks()
+			}
+
+			switch {
+			case pullWorker != nil && pullTask == nil:
+				d.triggerWorkerIdle()
+			case pullWorker == nil && pullTask != nil:
+				d.triggerTaskStarved()
+			}
+
+			return
+		}
+	}
+}
+
+// выдаёт задачу воркеру и запускает её выполнение
+func (d *SimpleDispatcher) executeTask(workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem, profiler workers.Profiler) {
+	task, metadata := eventTask(taskItem)
+
+	if profiler != nil {
+		profiler.OnDispatch(task.Id(), workerItem.Id())
+	}
+
+	// воркер с незанятой ёмкостью сразу возвращается в очередь и может получить следующую задачу
+	if workerItem.Acquire() < int64(workerItem.Capacity()) {
+		_ = d.workers.Push(workerItem)
+	}
+
+	// о выполнении широковещательной задачи сообщается один раз для всех частей
+	if part, ok := taskItem.Task().(*broadcastPart); ok {
+		d.triggerBroadcastStart(part, workerItem)
+	} else {
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskExecuteStart, task, metadata, workerItem.Worker(), workerItem.Metadata())
+	}
+
+	d.wg.Add(1)
+	atomic.AddInt64(&d.runningTasks, 1)
+	go d.doRunTask(workerItem, taskItem)
+}
+
+// сообщает, что свободные воркеры простаивают без задач, аргументы - количество свободных воркеров и размер пула
+func (d *SimpleDispatcher) triggerWorkerIdle() {
+	if !d.allowPoolEvent(&d.workerIdleAt) {
+		return
+	}
+
+	all := d.workers.GetAll()
+	idle := 0
+
+	for _, item := range all {
+		if w := item.(*manager.WorkersManagerItem); w.InFlight() == 0 && !w.IsRetired() {
+			idle++
+		}
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventWorkerIdle, idle, len(all))
+}
+
+// сообщает, что готовые к запуску задачи ждут свободного воркера, аргументы - количество таких задач и размер пула
+func (d *SimpleDispatcher) triggerTaskStarved() {
+	if !d.allowPoolEvent(&d.taskStarvedAt) {
+		return
+	}
+
+	waiting := 0
+	for _, item := range d.tasks.GetAll() {
+		if !item.IsLocked() {
+			waiting++
+		}
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskStarved, waiting, len(d.workers.GetAll()))
+}
+
+func (d *SimpleDispatcher) allowPoolEvent(last *int64) bool {
+	now := d.clock.Now().UnixNano()
+	prev := atomic.LoadInt64(last)
+
+	if prev != 0 && now-prev < int64(poolEventsInterval) {
+		return false
+	}
+
+	return atomic.CompareAndSwapInt64(last, prev, now)
+}
+
+// завершает задачи, которые дольше допустимого ждут свободного воркера
+func (d *SimpleDispatcher) failWorkerWaitTasks() {
+	now := d.clock.Now()
+
+	for _, item := range d.tasks.GetAll() {
+		taskItem := item.(*manager.TasksManagerItem)
+
+		t, ok := taskItem.Task().(workers.TaskWithWorkerWaitTimeout)
+		if !ok || taskItem.IsLocked() || !taskItem.IsWait() {
+			continue
+		}
+
+		// ожидание отсчитывается с момента, когда запуск стал возможен, а не с первого добавления задачи
+		waitSince := *taskItem.AllowStartAt()
+		if readySince := taskItem.ReadySince(); readySince.After(waitSince) {
+			waitSince = *readySince
+		}
+
+		timeout := t.WorkerWaitTimeout()
+		if timeout <= 0 || now.Sub(waitSince) < timeout {
+			continue
+		}
+
+		d.setStatusTask(taskItem, workers.TaskStatusNoWorker)
+		d.removeFinishedTask(taskItem)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskRemove, taskItem.Task(), taskItem.Metadata())
+	}
+}
+
+// ищет среди свободных воркеров первого, готового принять задачу, отказавшие воркеры возвращаются в очередь
+func (d *SimpleDispatcher) pullAcceptingWorker(worker *manager.WorkersManagerItem, task workers.Task) *manager.WorkersManagerItem {
+	var refused []workers.ManagerItem
+	defer func() {
+		for _, w := range refused {
+			_ = d.workers.Push(w)
+		}
+	}()
+
+	for worker != nil {
+		if workerAccepts(worker.Worker(), task) {
+			return worker
+		}
+
+		refused = append(refused, worker)
+
+		next := d.pullWorker()
+		if next == nil {
+			return nil
+		}
+
+		worker = next.(*manager.WorkersManagerItem)
+	}
+
+	return nil
+}
+
+func workerAccepts(worker workers.Worker, task workers.Task) bool {
+	if part, ok := task.(*broadcastPart); ok {
+		if part.worker.Id() != worker.Id() {
+			return false
+		}
+
+		task = part.Task
+	}
+
+	if t, ok := task.(workers.TaskWithKind); ok && t.Kind() != "" {
+		if w, ok := worker.(workers.WorkerWithKinds); ok {
+			supported := false
+
+			for _, kind := range w.Kinds() {
+				if kind == t.Kind() {
+					supported = true
+					break
+				}
+			}
+
+			if !supported {
+				return false
 			}
-		}()
+		}
+	}
+
+	if w, ok := worker.(workers.WorkerWithAccept); ok {
+		return w.CanAccept(task)
+	}
+
+	return true
+}
+
+const (
+	runTaskStateRunning uint32 = iota
+	runTaskStateFinished
+	runTaskStateInterrupted
+	runTaskStateAbandoned
+)
+
+// задача выполняется прямо в горутине doRunTask, отдельная горутина для отслеживания
+// отмены контекста запускается через context.AfterFunc только когда контекст действительно отменён
+func (d *SimpleDispatcher) doRunTask(workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem) {
+	task := taskItem.Task()
+
+	// в метаданных воркера видна исходная задача, а не её широковещательная часть
+	current, _ := eventTask(taskItem)
+	workerItem.SetTask(current)
+	d.setStatusWorker(workerItem, workers.WorkerStatusProcess)
+
+	taskItem.SetAttempts(taskItem.Attempts() + 1)
+	d.setStatusTask(taskItem, workers.TaskStatusProcess)
+
+	now := d.clock.Now()
+	if taskItem.Attempts() == 1 {
+		taskItem.SetFirstStartedAt(now)
+	}
+	taskItem.SetLastStartedAt(now)
+
+	ctx := workers.NewContextWithAttempt(d.ctx, taskItem.Attempts())
+	ctx = workers.NewContextWithTaskId(ctx, task.Id())
+	ctx = workers.NewContextWithWorkerId(ctx, workerItem.Id())
+	ctx = workers.NewContextWithCleanup(ctx)
+	ctx = workers.NewContextWithHeartbeat(ctx, func() {
+		taskItem.SetLastHeartbeatAt(d.clock.Now())
+	})
+
+	if decorator := d.ContextDecorator(); decorator != nil {
+		if decorated := decorator(ctx, task); decorated != nil {
+			ctx = decorated
+		}
+	}
+
+	var ctxCancel context.CancelFunc
+
+	if deadline := taskDeadline(task, now, d.DefaultTaskTimeout()); !deadline.IsZero() {
+		ctx, ctxCancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, ctxCancel = context.WithCancel(ctx)
+	}
+
+	defer ctxCancel()
+	taskItem.SetCancel(ctxCancel)
+	workerItem.SetTaskCancel(taskItem.Id(), ctxCancel)
+
+	// задачу могли удалить между выдачей воркеру и установкой функции отмены
+	if taskItem.IsRemoved() || isCompletedBroadcastPart(taskItem) {
+		ctxCancel()
+	}
+
+	// результат отправляет тот, кто первым сменит состояние: сама задача или обработчик отмены
+	state := runTaskStateRunning
+	finished := make(chan struct{})
 
-		result, err := workerItem.Worker().RunTask(ctx, task)
+	stopWatch := context.AfterFunc(ctx, func() {
+		if !atomic.CompareAndSwapUint32(&state, runTaskStateRunning, runTaskStateInterrupted) {
+			return
+		}
+
+		defer d.wg.Done()
+		d.doRunTaskInterrupted(ctx, workerItem, taskItem, &state, finished)
+	})
+
+	stopHeartbeat := d.watchHeartbeat(ctx, workerItem, taskItem, now)
+	stopHeartbeatEvents := d.triggerHeartbeats(ctx, workerItem, taskItem, now)
 
-		done <- SimpleDispatcherResult{
+	result, err := d.runTask(ctx, workerItem.Worker(), task)
+	close(finished)
+	stopHeartbeat()
+	stopHeartbeatEvents()
+
+	if atomic.CompareAndSwapUint32(&state, runTaskStateRunning, runTaskStateFinished) {
+		stopWatch()
+
+		r := SimpleDispatcherResult{
 			workerItem: workerItem,
 			taskItem:   taskItem,
 			result:     result,
 			err:        err,
+			executed:   true,
+		}
+
+		// задача вернулась уже после отмены контекста, итог тот же, что и у обработчика отмены
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			r.result = nil
+			r.err = ctxErr
+			r.cancel = ctxErr == context.Canceled
+			r.executed = false
+		}
+
+		d.runCleanup(ctx)
+		d.sendResult(r)
+		d.wg.Done()
+
+		return
+	}
+
+	// брошенная задача всё же вернулась, только теперь её ресурсы можно освободить
+	if atomic.CompareAndSwapUint32(&state, runTaskStateAbandoned, runTaskStateFinished) {
+		atomic.AddInt64(&d.abandonedTasks, -1)
+		d.runCleanup(ctx)
+	}
+}
+
+func (d *SimpleDispatcher) runTask(ctx context.Context, worker workers.Worker, task workers.Task) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = workers.NewPanicError(recovered)
 		}
 	}()
 
-	select {
-	case <-ctx.Done():
-		// TODO:
-		//<-done
+	// воркер получает исходную задачу, а не её широковещательную часть
+	if part, ok := task.(*broadcastPart); ok {
+		task = part.Task
+	}
 
-		d.results <- SimpleDispatcherResult{
-			workerItem: workerItem,
-			taskItem:   taskItem,
-			err:        ctx.Err(),
-			cancel:     ctx.Err() == context.Canceled,
+	return d.chainMiddlewares(worker.RunTask)(ctx, task)
+}
+
+func (d *SimpleDispatcher) runCleanup(ctx context.Context) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			d.Logger().Error("Task cleanup panic", "panic", recovered)
 		}
+	}()
 
-	case r := <-done:
-		d.results <- r
+	workers.RunCleanup(ctx)
+}
+
+// следит за сигналами жизни задачи и сообщает о зависании, если их не было дольше таймаута,
+// после отмены контекста задачи слежение прекращается
+func (d *SimpleDispatcher) watchHeartbeat(ctx context.Context, workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem, startedAt time.Time) func() {
+	timeout := d.HeartbeatTimeout()
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	var (
+		mutex   sync.Mutex
+		stopped bool
+		timer   *time.Timer
+	)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	timer = time.AfterFunc(timeout, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if stopped || ctx.Err() != nil {
+			return
+		}
+
+		last := startedAt
+		if heartbeat := taskItem.LastHeartbeatAt(); heartbeat != nil && heartbeat.After(last) {
+			last = *heartbeat
+		}
+
+		wait := timeout - d.clock.Since(last)
+		if wait <= 0 {
+			task, metadata := eventTask(taskItem)
+			d.listeners.AsyncTrigger(d.Context(), workers.EventTaskStuck, task, metadata, workerItem.Worker(), workerItem.Metadata())
+			wait = timeout
+		}
+
+		timer.Reset(wait)
+	})
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		stopped = true
+		timer.Stop()
+	}
+}
+
+// периодически сообщает о том, что задача всё ещё выполняется, до её завершения или отмены контекста
+func (d *SimpleDispatcher) triggerHeartbeats(ctx context.Context, workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem, startedAt time.Time) func() {
+	interval := d.HeartbeatInterval()
+	if interval <= 0 {
+		return func() {}
+	}
+
+	var (
+		mutex   sync.Mutex
+		stopped bool
+		timer   *time.Timer
+	)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	timer = time.AfterFunc(interval, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if stopped || ctx.Err() != nil {
+			return
+		}
+
+		task, metadata := eventTask(taskItem)
+		d.listeners.AsyncTrigger(d.Context(), workers.EventTaskHeartbeat, task, metadata, workerItem.Worker(), workerItem.Metadata(), d.clock.Since(startedAt))
+		timer.Reset(interval)
+	})
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		stopped = true
+		timer.Stop()
+	}
+}
+
+func (d *SimpleDispatcher) doRunTaskInterrupted(ctx context.Context, workerItem *manager.WorkersManagerItem, taskItem *manager.TasksManagerItem, state *uint32, finished <-chan struct{}) {
+	var hardTimeout time.Duration
+	if t, ok := taskItem.Task().(workers.TaskWithHardTimeout); ok {
+		hardTimeout = t.HardTimeout()
+	}
+
+	// очистка выполняется только после возврата RunTask, у брошенной задачи её выполнит doRunTask
+	if hardTimeout > 0 {
+		timer := time.NewTimer(hardTimeout)
+
+		select {
+		case <-finished:
+			timer.Stop()
+			d.runCleanup(ctx)
+
+		case <-timer.C:
+			if d.abandonTask(state, finished) {
+				d.runCleanup(ctx)
+			}
+
+			task, metadata := eventTask(taskItem)
+			d.listeners.AsyncTrigger(d.Context(), workers.EventTaskStuck, task, metadata, workerItem.Worker(), workerItem.Metadata())
+		}
+	} else {
+		<-finished
+		d.runCleanup(ctx)
+	}
+
+	d.sendResult(SimpleDispatcherResult{
+		workerItem: workerItem,
+		taskItem:   taskItem,
+		err:        ctx.Err(),
+		cancel:     ctx.Err() == context.Canceled,
+	})
+}
+
+// после остановки диспетчера сборщик результатов уже не читает канал, поэтому результат обрабатывается на месте
+func (d *SimpleDispatcher) sendResult(result SimpleDispatcherResult) {
+	select {
+	case d.results <- result:
+	case <-d.ctx.Done():
+		d.doResult(result)
+	}
+}
+
+// помечает задачу брошенной, возвращает true, если она всё же успела вернуться из RunTask
+func (d *SimpleDispatcher) abandonTask(state *uint32, finished <-chan struct{}) bool {
+	if !atomic.CompareAndSwapUint32(state, runTaskStateInterrupted, runTaskStateAbandoned) {
+		return false
+	}
+
+	atomic.AddInt64(&d.abandonedTasks, 1)
+
+	select {
+	case <-finished:
+		// задача успела вернуться до того, как её пометили брошенной
+		if atomic.CompareAndSwapUint32(state, runTaskStateAbandoned, runTaskStateFinished) {
+			atomic.AddInt64(&d.abandonedTasks, -1)
+			return true
+		}
+	default:
 	}
+
+	return false
 }
 
+// сигналы объединяются: уже ожидающий сигнал гарантирует ещё один проход раздачи после текущего,
+// поэтому отправка не блокируется даже при одновременных вызовах
 func (d *SimpleDispatcher) notifyAllowExecuteTasks() {
-	if d.IsStatus(workers.DispatcherStatusProcess) && len(d.allowExecuteTasks) == 0 {
-		d.allowExecuteTasks <- struct{}{}
+	if !d.IsStatus(workers.DispatcherStatusProcess) {
+		return
+	}
+
+	select {
+	case d.allowExecuteTasks <- struct{}{}:
+	default:
 	}
 }
 
@@ -416,6 +2950,15 @@ func (d *SimpleDispatcher) setStatusDispatcher(status workers.Status) {
 	d.listeners.AsyncTrigger(d.Context(), workers.EventDispatcherStatusChanged, d, status, last)
 }
 
+func (d *SimpleDispatcher) swapStatusDispatcher(old, status workers.DispatcherStatus) bool {
+	if !d.CompareAndSwapStatus(old, status) {
+		return false
+	}
+
+	d.listeners.AsyncTrigger(d.Context(), workers.EventDispatcherStatusChanged, d, status, old)
+	return true
+}
+
 func (d *SimpleDispatcher) setStatusWorker(worker workers.ManagerItem, status workers.Status) {
 	last := worker.Status()
 	worker.SetStatus(status)
@@ -427,9 +2970,23 @@ func (d *SimpleDispatcher) setStatusTask(task workers.ManagerItem, status worker
 	last := task.Status()
 	task.SetStatus(status)
 	item := task.(*manager.TasksManagerItem)
+
+	// части широковещательных задач событий не генерируют
+	if _, ok := item.Task().(*broadcastPart); ok {
+		return
+	}
+
 	d.listeners.AsyncTrigger(d.Context(), workers.EventTaskStatusChanged, item.Task(), item.Metadata(), status, last)
 }
 
+// интервал страховочного прохода распределения задач, 0 отключает его, и задачи раздаются только по событиям:
+// добавлению задач и воркеров, завершению выполнений и наступлению отложенных запусков. Задачи, пропущенные
+// проходом, а также отложенные задачи стороннего менеджера задач при этом проверяются раз в fallbackWakeupInterval
 func (d *SimpleDispatcher) SetTickerExecuteTasksDuration(t time.Duration) {
+	atomic.StoreInt64(&d.tickerPeriod, int64(t))
 	d.tickerAllowExecuteTasks.SetDuration(t)
 }
+
+func (d *SimpleDispatcher) TickerExecuteTasksDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.tickerPeriod))
+}
@@ -0,0 +1,3961 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/listener"
+	"github.com/mrsmtvd/go-workers/manager"
+	"github.com/mrsmtvd/go-workers/task"
+	"github.com/mrsmtvd/go-workers/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func runDispatcher(t testing.TB, d *SimpleDispatcher) chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- d.Run()
+	}()
+
+	for i := 0; i < 100 && !d.IsStatus(workers.DispatcherStatusProcess); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if !assert.Equal(t, workers.DispatcherStatusProcess, d.Status()) {
+		t.FailNow()
+	}
+
+	return done
+}
+
+func eventChannel(d *SimpleDispatcher, event workers.Event) chan []interface{} {
+	ch := make(chan []interface{}, 100)
+
+	d.AddListener(event, listener.NewFunctionListener(func(_ context.Context, _ workers.Event, _ time.Time, args ...interface{}) {
+		ch <- args
+	}))
+
+	return ch
+}
+
+func waitEvent(t testing.TB, ch chan []interface{}) []interface{} {
+	select {
+	case args := <-ch:
+		return args
+	case <-time.After(time.Second * 5):
+		t.Fatal("Event wasn't fired")
+	}
+
+	return nil
+}
+
+func TestCancelDuringExecuteFiresStopEvent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	done := runDispatcher(t, d)
+
+	d.AddWorker(worker.NewSimpleWorker())
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	d.AddTask(tsk)
+
+	waitEvent(t, starts)
+	d.Cancel()
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, context.Canceled, args[5])
+	assert.Equal(t, true, args[6])
+	assert.Equal(t, workers.TaskStatusCancel, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Dispatcher wasn't stopped")
+	}
+}
+
+func TestListenerPanicDoesNotStopDispatcher(t *testing.T) {
+	d := NewSimpleDispatcher()
+	panics := eventChannel(d, workers.EventListenerPanic)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	d.AddListener(workers.EventTaskExecuteStart, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		panic("listener failed")
+	}))
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	for i := 0; i < 2; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+
+		args := waitEvent(t, stops)
+		assert.Nil(t, args[5])
+
+		args = waitEvent(t, panics)
+		assert.Equal(t, workers.EventTaskExecuteStart, args[1])
+		assert.Equal(t, "listener failed", args[2])
+		assert.NotEmpty(t, args[3])
+	}
+}
+
+func TestCleanupRunsBeforeStopEvent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	cleaned := make(chan string, 10)
+
+	d.AddListener(workers.EventTaskExecuteStop, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		cleaned <- "stop"
+	}))
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		workers.OnCleanup(ctx, func() {
+			cleaned <- "cleanup"
+		})
+
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	tsk.SetTimeout(time.Millisecond * 50)
+	d.AddTask(tsk)
+
+	for _, expected := range []string{"cleanup", "stop"} {
+		select {
+		case actual := <-cleaned:
+			assert.Equal(t, expected, actual)
+		case <-time.After(time.Second * 5):
+			t.Fatal("Task wasn't finished")
+		}
+	}
+
+	select {
+	case actual := <-cleaned:
+		t.Fatalf("Unexpected %s", actual)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestCleanupWaitsForRunTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var returned, cleanedAfterReturn int32
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		workers.OnCleanup(ctx, func() {
+			atomic.StoreInt32(&cleanedAfterReturn, atomic.LoadInt32(&returned))
+		})
+
+		// задача не сразу реагирует на отмену
+		<-ctx.Done()
+		time.Sleep(time.Millisecond * 100)
+		atomic.StoreInt32(&returned, 1)
+
+		return nil, ctx.Err()
+	})
+	tsk.SetTimeout(time.Millisecond * 50)
+	d.AddTask(tsk)
+
+	waitEvent(t, stops)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cleanedAfterReturn))
+}
+
+func TestRemoveTaskDuringExecute(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+	removes := eventChannel(d, workers.EventTaskRemove)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	cancelled := make(chan struct{})
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	tsk.SetRepeats(-1)
+	d.AddTask(tsk)
+
+	waitEvent(t, starts)
+	assert.NotNil(t, d.GetTaskMetadata(tsk.Id()))
+
+	d.RemoveTask(tsk)
+	assert.Equal(t, tsk, waitEvent(t, removes)[0])
+	assert.Nil(t, d.GetTaskMetadata(tsk.Id()))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Task context wasn't cancelled")
+	}
+
+	select {
+	case <-stops:
+		t.Fatal("Result of removed task wasn't dropped")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	assert.Len(t, d.GetTasks(), 0)
+
+	next := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTask(next)
+	assert.Equal(t, next, waitEvent(t, stops)[0])
+}
+
+func TestHeartbeatWatchdog(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetHeartbeatTimeout(time.Millisecond * 50)
+	stuck := eventChannel(d, workers.EventTaskStuck)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	silent := make(chan struct{})
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		for i := 0; i < 10; i++ {
+			assert.True(t, workers.Heartbeat(ctx))
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		close(silent)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	d.AddTask(tsk)
+
+	select {
+	case <-stuck:
+		t.Fatal("Task with heartbeats was marked as stuck")
+	case <-silent:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Task wasn't executed")
+	}
+
+	args := waitEvent(t, stuck)
+	assert.Equal(t, tsk, args[0])
+	assert.NotNil(t, args[1].(workers.Metadata)[workers.TaskMetadataLastHeartbeatAt])
+}
+
+func TestHeartbeatEvents(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetHeartbeatInterval(time.Millisecond * 30)
+	assert.Equal(t, time.Millisecond*30, d.HeartbeatInterval())
+	heartbeats := eventChannel(d, workers.EventTaskHeartbeat)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond * 100)
+		return nil, nil
+	})
+	d.AddTask(tsk)
+
+	first := waitEvent(t, heartbeats)
+	second := waitEvent(t, heartbeats)
+	assert.Equal(t, tsk, first[0])
+	assert.Equal(t, workers.TaskStatusProcess, first[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.GreaterOrEqual(t, first[4].(time.Duration), time.Millisecond*30)
+	assert.Greater(t, second[4].(time.Duration), first[4].(time.Duration))
+
+	waitEvent(t, stops)
+
+	// после завершения задачи события прекращаются, уже отправленные асинхронно могут прийти позже остановки
+	time.Sleep(time.Millisecond * 50)
+	for len(heartbeats) > 0 {
+		<-heartbeats
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, heartbeats)
+}
+
+func TestShutdownWaitsRunningTasks(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	done := runDispatcher(t, d)
+	statuses := eventChannel(d, workers.EventDispatcherStatusChanged)
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond * 100)
+		return "done", nil
+	}))
+
+	waitEvent(t, starts)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	assert.NoError(t, d.Shutdown(ctx))
+	assert.Equal(t, workers.DispatcherStatusWait, d.Status())
+	assert.Equal(t, workers.DispatcherStatusShutdown, waitEvent(t, statuses)[1])
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, "done", args[4])
+	assert.Nil(t, args[5])
+	assert.NoError(t, <-done)
+}
+
+func TestWaitTaskStatus(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	assert.Error(t, d.WaitTaskStatus(ctx, "unknown", workers.TaskStatusSuccess))
+
+	release := make(chan struct{})
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	d.AddTask(tsk)
+	d.AddWorker(worker.NewSimpleWorker())
+
+	assert.NoError(t, d.WaitTaskStatus(ctx, tsk.Id(), workers.TaskStatusProcess))
+	close(release)
+	assert.NoError(t, d.WaitTaskStatus(ctx, tsk.Id(), workers.TaskStatusSuccess))
+
+	blocked := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	d.AddTask(blocked)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		d.RemoveTask(blocked)
+	}()
+
+	assert.Error(t, d.WaitTaskStatus(ctx, blocked.Id(), workers.TaskStatusSuccess))
+
+	// последняя неудачная попытка удаляет задачу без EventTaskRemove
+	failing := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond * 50)
+		return nil, errors.New("failed")
+	})
+	d.AddTask(failing)
+
+	err := d.WaitTaskStatus(ctx, failing.Id(), workers.TaskStatusSuccess)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAddTaskWithMetadata(t *testing.T) {
+	d := NewSimpleDispatcher()
+	adds := eventChannel(d, workers.EventTaskAdd)
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+
+	custom := workers.Metadata{workers.TaskMetadataStatus: "request-1"}
+	assert.NoError(t, d.AddTaskWithMetadata(tsk, custom))
+
+	metadata := d.GetTaskMetadata(tsk.Id())
+	assert.Equal(t, workers.TaskStatusWait, metadata[workers.TaskMetadataStatus])
+	assert.Equal(t, custom, metadata[workers.TaskMetadataCustom])
+
+	args := waitEvent(t, adds)
+	assert.Equal(t, custom, args[1].(workers.Metadata)[workers.TaskMetadataCustom])
+}
+
+func TestGlobalCircuitBreaker(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetGlobalCircuitBreaker(0.5, time.Second*5, time.Millisecond*100)
+	opens := eventChannel(d, workers.EventDispatcherCircuitOpen)
+	statuses := eventChannel(d, workers.EventDispatcherStatusChanged)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	for i := 0; i < circuitBreakerMinResults; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, errors.New("downstream is broken")
+		}))
+	}
+
+	args := waitEvent(t, opens)
+	assert.Equal(t, d, args[0])
+	assert.Equal(t, float64(1), args[1])
+
+	for {
+		args = waitEvent(t, statuses)
+		if args[1] == workers.DispatcherStatusPause {
+			break
+		}
+	}
+
+	args = waitEvent(t, statuses)
+	assert.Equal(t, workers.DispatcherStatusProcess, args[1])
+	assert.Equal(t, workers.DispatcherStatusPause, args[2])
+}
+
+func TestMetadataIsCopy(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTaskWithMetadata(tsk, workers.Metadata{workers.TaskMetadataStatus: "request-1"})
+
+	metadata := d.GetTaskMetadata(tsk.Id())
+	allowStartAt := *metadata[workers.TaskMetadataAllowStartAt].(*time.Time)
+
+	*metadata[workers.TaskMetadataAllowStartAt].(*time.Time) = allowStartAt.Add(time.Hour)
+	metadata[workers.TaskMetadataCustom].(workers.Metadata)[workers.TaskMetadataStatus] = "changed"
+	metadata[workers.TaskMetadataStatus] = workers.TaskStatusCancel
+
+	metadata = d.GetTaskMetadata(tsk.Id())
+	assert.Equal(t, allowStartAt, *metadata[workers.TaskMetadataAllowStartAt].(*time.Time))
+	assert.Equal(t, "request-1", metadata[workers.TaskMetadataCustom].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.Equal(t, workers.TaskStatusWait, metadata[workers.TaskMetadataStatus])
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	metadata = d.GetWorkerMetadata(w.Id())
+	metadata[workers.WorkerMetadataStatus] = workers.WorkerStatusCancel
+	assert.Equal(t, workers.WorkerStatusWait, d.GetWorkerMetadata(w.Id())[workers.WorkerMetadataStatus])
+
+	l := listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {})
+	d.AddListener(workers.EventTaskAdd, l)
+
+	metadata = d.GetListenerMetadata(l.Id())
+	metadata[workers.ListenerMetadataEvents].([]workers.Event)[0] = workers.EventTaskRemove
+	assert.Equal(t, []workers.Event{workers.EventTaskAdd}, d.GetListenerMetadata(l.Id())[workers.ListenerMetadataEvents])
+}
+
+func TestAddListenerDetach(t *testing.T) {
+	d := NewSimpleDispatcher()
+	removes := eventChannel(d, workers.EventListenerRemove)
+
+	var calls int64
+	l := listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		atomic.AddInt64(&calls, 1)
+	})
+
+	detach, err := d.AddListener(workers.EventTaskAdd, l)
+	assert.NoError(t, err)
+
+	d.listeners.Trigger(context.Background(), workers.EventTaskAdd)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	detach()
+	detach()
+
+	d.listeners.Trigger(context.Background(), workers.EventTaskAdd)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	args := waitEvent(t, removes)
+	assert.Equal(t, workers.EventTaskAdd, args[0])
+	assert.Equal(t, l, args[1])
+
+	// повторный вызов не отписывает слушателя ещё раз
+	time.Sleep(time.Millisecond * 50)
+	assert.Empty(t, removes)
+}
+
+func TestRemoveAllListeners(t *testing.T) {
+	d := NewSimpleDispatcher()
+	removes := eventChannel(d, workers.EventListenerRemove)
+
+	var calls int64
+	attached := map[string]bool{}
+
+	for i := 0; i < 3; i++ {
+		l := listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+			atomic.AddInt64(&calls, 1)
+		})
+		attached[l.Id()] = true
+		d.AddListener(workers.EventTaskAdd, l)
+	}
+
+	kept := listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {})
+	d.AddListener(workers.EventTaskRemove, kept)
+
+	d.RemoveAllListeners(workers.EventTaskAdd)
+
+	for i := 0; i < 3; i++ {
+		args := waitEvent(t, removes)
+		assert.Equal(t, workers.EventTaskAdd, args[0])
+		assert.True(t, attached[args[1].(workers.Listener).Id()])
+		delete(attached, args[1].(workers.Listener).Id())
+	}
+
+	d.listeners.Trigger(context.Background(), workers.EventTaskAdd)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&calls))
+
+	subscriptions := d.ListenerSubscriptions()
+	assert.Len(t, subscriptions, 2)
+	assert.Equal(t, []workers.Event{workers.EventTaskRemove}, subscriptions[kept.Id()])
+
+	d.ClearListeners()
+	assert.Empty(t, d.GetListeners())
+	assert.Empty(t, d.ListenerSubscriptions())
+}
+
+type testProfiler struct {
+	calls chan string
+}
+
+func (p *testProfiler) OnNoWorker() {
+	p.calls <- "no worker"
+}
+
+func (p *testProfiler) OnNoTask() {
+	p.calls <- "no task"
+}
+
+func (p *testProfiler) OnDispatch(taskId, workerId string) {
+	p.calls <- taskId + " " + workerId
+}
+
+func TestProfiler(t *testing.T) {
+	p := &testProfiler{calls: make(chan string, 100)}
+
+	d := NewSimpleDispatcher()
+	d.SetProfiler(p)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	wait := func(expected string) {
+		for {
+			select {
+			case call := <-p.calls:
+				if call == expected {
+					return
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("Profiler wasn't called with %s", expected)
+			}
+		}
+	}
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTask(tsk)
+	wait("no worker")
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+	wait(tsk.Id() + " " + w.Id())
+	wait("no task")
+}
+
+func TestMaxLifetime(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	d.SetMaxLifetime(time.Hour)
+	expired := eventChannel(d, workers.EventDispatcherLifetimeExpired)
+
+	done := runDispatcher(t, d)
+
+	// срок жизни отсчитывается по часам диспетчера
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, expired)
+	fc.Increment(time.Hour)
+
+	args := waitEvent(t, expired)
+	assert.Equal(t, time.Hour, args[1])
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Dispatcher wasn't stopped")
+	}
+}
+
+func TestRescheduleEvent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	reschedules := eventChannel(d, workers.EventTaskReschedule)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetRepeats(2)
+	tsk.SetRepeatInterval(time.Hour)
+
+	started := time.Now()
+	d.AddTask(tsk)
+
+	args := waitEvent(t, reschedules)
+	assert.Equal(t, tsk, args[0])
+	assert.WithinDuration(t, started.Add(time.Hour), args[2].(time.Time), time.Second)
+	assert.Equal(t, workers.TaskRescheduleReasonInterval, args[3])
+}
+
+func TestBoostConcurrency(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	boostStops := eventChannel(d, workers.EventWorkerBoostStop)
+	removes := eventChannel(d, workers.EventWorkerRemove)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	factory := func() workers.Worker {
+		return worker.NewSimpleWorker()
+	}
+
+	assert.Error(t, d.BoostConcurrency(0, time.Second, factory))
+	assert.Error(t, d.BoostConcurrency(2, time.Second, nil))
+
+	release := make(chan struct{})
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}))
+
+	watchers := fc.WatcherCount()
+	assert.NoError(t, d.BoostConcurrency(2, time.Minute, factory))
+	assert.Len(t, d.GetWorkers(), 2)
+
+	// срок отсчитывается по часам диспетчера
+	assert.Eventually(t, func() bool {
+		return fc.WatcherCount() > watchers
+	}, time.Second*5, time.Millisecond*10)
+	fc.Increment(time.Minute)
+
+	waitEvent(t, boostStops)
+	waitEvent(t, removes)
+	assert.Len(t, d.GetWorkers(), 1)
+
+	close(release)
+	waitEvent(t, removes)
+	assert.Len(t, d.GetWorkers(), 0)
+}
+
+func TestBoostConcurrencyStoppedWithDispatcher(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	boostStops := eventChannel(d, workers.EventWorkerBoostStop)
+
+	done := runDispatcher(t, d)
+
+	assert.NoError(t, d.BoostConcurrency(1, time.Minute, func() workers.Worker {
+		return worker.NewSimpleWorker()
+	}))
+
+	d.Cancel()
+	assert.NoError(t, <-done)
+
+	fc.Increment(time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, boostStops)
+}
+
+func TestResultCache(t *testing.T) {
+	d := NewSimpleDispatcher()
+	hits := eventChannel(d, workers.EventTaskCacheHit)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	newTask := func() *task.FunctionTask {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return atomic.AddInt64(&runs, 1), nil
+		})
+		tsk.SetCache("sum", time.Minute)
+
+		return tsk
+	}
+
+	first := newTask()
+	d.AddTask(first)
+	assert.Equal(t, int64(1), waitEvent(t, stops)[4])
+
+	second := newTask()
+	d.AddTask(second)
+
+	args := waitEvent(t, hits)
+	assert.Equal(t, second, args[0])
+	assert.Equal(t, workers.TaskStatusSuccess, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.Equal(t, int64(1), args[2])
+	assert.Equal(t, int64(1), atomic.LoadInt64(&runs))
+}
+
+func TestReplaceWorker(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	old := worker.NewSimpleWorker()
+	d.AddWorker(old)
+
+	release := make(chan struct{})
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}))
+	waitEvent(t, starts)
+
+	assert.Error(t, d.ReplaceWorker("unknown", worker.NewSimpleWorker()))
+
+	replacement := worker.NewSimpleWorker()
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- d.ReplaceWorker(old.Id(), replacement)
+	}()
+
+	select {
+	case <-replaced:
+		t.Fatal("Worker was replaced before its task finished")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	close(release)
+
+	select {
+	case err := <-replaced:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Worker wasn't replaced")
+	}
+
+	assert.Equal(t, []workers.Worker{replacement}, d.GetWorkers())
+
+	for i := 0; i < 2; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	var created []workers.Worker
+	assert.NoError(t, d.RollingReplaceAll(func() workers.Worker {
+		w := worker.NewSimpleWorker()
+		created = append(created, w)
+		return w
+	}, 1))
+
+	assert.Len(t, created, 3)
+	assert.ElementsMatch(t, created, d.GetWorkers())
+}
+
+func TestIdleReason(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+
+	assert.Equal(t, "dispatcher isn't running", d.IdleReason())
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	assert.Equal(t, "no tasks queued", d.IdleReason())
+
+	future := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	startedAt := time.Now().Add(time.Hour)
+	future.SetStartedAt(startedAt)
+	d.AddTask(future)
+
+	assert.Equal(t, "all tasks scheduled for the future (next at "+startedAt.Format(time.RFC3339)+")", d.IdleReason())
+
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := 0; i < 2; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			<-release
+			return nil, nil
+		}))
+	}
+
+	assert.Equal(t, "no idle workers", d.IdleReason())
+
+	d.AddWorker(worker.NewSimpleWorker())
+	waitEvent(t, starts)
+	assert.Equal(t, "no idle workers", d.IdleReason())
+
+	d.Pause()
+	assert.Equal(t, "paused", d.IdleReason())
+}
+
+func TestWaitIdle(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	for i := 0; i < 5; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			time.Sleep(time.Millisecond * 20)
+			atomic.AddInt64(&runs, 1)
+			return nil, nil
+		}))
+	}
+
+	future := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	future.SetStartedAt(time.Now().Add(time.Hour))
+	d.AddTask(future)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, d.WaitIdle(ctx))
+			assert.Equal(t, int64(5), atomic.LoadInt64(&runs))
+		}()
+	}
+	wg.Wait()
+
+	d.AddTask(task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+
+	short, shortCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer shortCancel()
+
+	assert.Equal(t, context.DeadlineExceeded, d.WaitIdle(short))
+}
+
+type backoffTask struct {
+	*task.FunctionTask
+
+	calls chan []interface{}
+}
+
+func (t *backoffTask) BackoffInterval(attempt int64, lastErr error) time.Duration {
+	t.calls <- []interface{}{attempt, lastErr}
+	return time.Millisecond * 100 << uint(attempt-1)
+}
+
+func TestBackoffInterval(t *testing.T) {
+	d := NewSimpleDispatcher()
+	reschedules := eventChannel(d, workers.EventTaskReschedule)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	failure := errors.New("downstream is broken")
+	tsk := &backoffTask{
+		FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, failure
+		}),
+		calls: make(chan []interface{}, 10),
+	}
+	tsk.SetRepeats(4)
+	tsk.SetRepeatInterval(time.Hour)
+	d.AddTask(tsk)
+
+	for attempt := int64(1); attempt <= 3; attempt++ {
+		assert.Equal(t, []interface{}{attempt, failure}, waitEvent(t, tsk.calls))
+
+		args := waitEvent(t, reschedules)
+		expected := time.Now().Add(time.Millisecond * 100 << uint(attempt-1))
+		assert.WithinDuration(t, expected, args[2].(time.Time), time.Millisecond*50)
+		assert.Equal(t, workers.TaskRescheduleReasonBackoff, args[3])
+	}
+}
+
+func TestCancelDoesNotLeakGoroutines(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	done := runDispatcher(t, d)
+
+	// рутины самого диспетчера завершаются вместе с ним, поэтому после остановки их должно стать не больше
+	base := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+		d.AddTask(task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+	}
+
+	for i := 0; i < 5; i++ {
+		waitEvent(t, starts)
+	}
+
+	d.Cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Dispatcher wasn't stopped")
+	}
+
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= base
+	}, time.Second*2, time.Millisecond*10)
+}
+
+func TestExecuteTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	failure := errors.New("downstream is broken")
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		if atomic.AddInt64(&runs, 1) < 3 {
+			return nil, failure
+		}
+
+		return "done", nil
+	})
+	tsk.SetRepeats(3)
+
+	result, err := d.ExecuteTask(context.Background(), tsk)
+	assert.NoError(t, err)
+	assert.Equal(t, "done", result)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&runs))
+
+	result, err = d.ExecuteTask(context.Background(), task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, failure
+	}))
+	assert.Nil(t, result)
+	assert.Equal(t, failure, err)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer ctxCancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	_, err = d.ExecuteTask(ctx, task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPauseResume(t *testing.T) {
+	d := NewSimpleDispatcher()
+	statuses := eventChannel(d, workers.EventDispatcherStatusChanged)
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	release := make(chan struct{})
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}))
+	waitEvent(t, starts)
+
+	assert.NoError(t, d.Pause())
+	for {
+		if waitEvent(t, statuses)[1] == workers.DispatcherStatusPause {
+			break
+		}
+	}
+	assert.Error(t, d.Pause())
+
+	// выполняющаяся задача завершается как обычно
+	close(release)
+	assert.Nil(t, waitEvent(t, stops)[5])
+
+	for i := 0; i < 3; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+	}
+
+	select {
+	case <-starts:
+		t.Fatal("Task was started while dispatcher is paused")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	assert.NoError(t, d.Resume())
+	assert.Equal(t, workers.DispatcherStatusProcess, waitEvent(t, statuses)[1])
+	assert.Error(t, d.Resume())
+
+	for i := 0; i < 3; i++ {
+		waitEvent(t, stops)
+	}
+}
+
+func TestStats(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := 0; i < 2; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			started <- struct{}{}
+			<-release
+			return nil, nil
+		}))
+	}
+
+	// статусы выставляются до запуска задачи, поэтому после её старта снимок уже согласован
+	<-started
+
+	delayed := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	delayed.SetStartedAt(time.Now().Add(time.Hour))
+	d.AddTask(delayed)
+
+	stats := d.Stats()
+	assert.Equal(t, map[workers.TaskStatus]int{
+		workers.TaskStatusProcess: 1,
+		workers.TaskStatusWait:    2,
+	}, stats.Tasks)
+	assert.Equal(t, map[workers.WorkerStatus]int{
+		workers.WorkerStatusProcess: 1,
+	}, stats.Workers)
+	assert.Equal(t, int64(1), stats.InFlight)
+	assert.Equal(t, 1, stats.Delayed)
+}
+
+func TestCancelTaskById(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	removes := eventChannel(d, workers.EventTaskRemove)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	cancelled := make(chan error, 1)
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	d.AddTask(tsk)
+	waitEvent(t, starts)
+
+	assert.True(t, d.CancelTaskById(tsk.Id()))
+
+	select {
+	case err := <-cancelled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Task context wasn't cancelled")
+	}
+
+	assert.Equal(t, tsk, waitEvent(t, removes)[0])
+	assert.False(t, d.CancelTaskById(tsk.Id()))
+
+	// итог отменённой задачи мог ещё не освободить воркер, тогда он удаляется после него
+	assert.True(t, d.RemoveWorkerById(w.Id()))
+	assert.Eventually(t, func() bool {
+		return len(d.GetWorkers()) == 0
+	}, time.Second*5, time.Millisecond*10)
+	assert.False(t, d.RemoveWorkerById(w.Id()))
+}
+
+func TestExecuteRateLimit(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetExecuteRateLimit(2, 1)
+
+	var (
+		mutex  sync.Mutex
+		starts []time.Time
+	)
+
+	d.AddListener(workers.EventTaskExecuteStart, listener.NewFunctionListener(func(_ context.Context, _ workers.Event, at time.Time, _ ...interface{}) {
+		mutex.Lock()
+		starts = append(starts, at)
+		mutex.Unlock()
+	}))
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	for i := 0; i < 5; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	for i := 0; i < 10; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+	}
+
+	for i := 0; i < 10; i++ {
+		waitEvent(t, stops)
+	}
+
+	// события доставляются асинхронно, последний запуск может прийти позже остановок
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return len(starts) == 10
+	}, time.Second, time.Millisecond*10)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Before(starts[j])
+	})
+
+	// 10 запусков при 2 в секунду без запаса занимают не меньше 9 интервалов по 500мс
+	if assert.Len(t, starts, 10) {
+		assert.True(t, starts[9].Sub(starts[0]) >= time.Millisecond*4400, "starts are spread over %s", starts[9].Sub(starts[0]))
+
+		for i := 1; i < len(starts); i++ {
+			assert.True(t, starts[i].Sub(starts[i-1]) >= time.Millisecond*450, "gap between starts %s", starts[i].Sub(starts[i-1]))
+		}
+	}
+}
+
+func TestExecuteRateLimitClock(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tasks := &recordingManager{Manager: manager.NewTasksManagerWithClock(fc)}
+	d := newSimpleDispatcher(context.Background(), fc, tasks, manager.NewWorkersManager())
+	d.SetExecuteRateLimit(2, 1)
+	d.SetTickerExecuteTasksDuration(0)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	for i := 0; i < 2; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+	}
+
+	waitEvent(t, stops)
+
+	// пока часы диспетчера стоят, ограничитель не будит раздачу задач по реальному времени
+	time.Sleep(time.Millisecond * 100)
+	pulls := atomic.LoadInt64(&tasks.pulls)
+	time.Sleep(time.Millisecond * 700)
+	assert.Equal(t, pulls, atomic.LoadInt64(&tasks.pulls))
+	assert.Empty(t, stops)
+
+	fc.Increment(time.Millisecond * 500)
+	waitEvent(t, stops)
+}
+
+func TestDeadlineBeforeStart(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	var runs int64
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&runs, 1)
+		return nil, nil
+	})
+	tsk.SetDeadline(time.Now().Add(-time.Second))
+	d.AddTask(tsk)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusFailByTimeout, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.Equal(t, w, args[2])
+	assert.ErrorIs(t, args[5].(error), ErrTaskDeadline)
+	assert.Equal(t, false, args[7])
+	assert.Equal(t, int64(0), atomic.LoadInt64(&runs))
+	assert.Equal(t, int64(1), d.ErrorCategories()[workers.ErrorCategoryTimeout])
+
+	expired := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&runs, 1)
+		return nil, nil
+	})
+	expired.SetDeadline(time.Now().Add(-time.Second))
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	_, err := d.ExecuteTask(ctx, expired)
+	assert.ErrorIs(t, err, ErrTaskDeadline)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&runs))
+}
+
+func TestDeadlineDuringRun(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	tsk.SetTimeout(time.Hour)
+	tsk.SetDeadline(time.Now().Add(time.Millisecond * 100))
+	d.AddTask(tsk)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, context.DeadlineExceeded, args[5])
+	assert.Equal(t, workers.TaskStatusFailByTimeout, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+}
+
+func TestTaskPanicError(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		panic("task failed")
+	}))
+
+	args := waitEvent(t, stops)
+	assert.Nil(t, args[4])
+
+	if err, ok := args[5].(*workers.PanicError); assert.True(t, ok) {
+		assert.Equal(t, "task failed", err.Value)
+		assert.NotEmpty(t, err.Stack)
+		assert.Equal(t, "Panic recovered: task failed", err.Error())
+	}
+
+	assert.Equal(t, int64(1), d.ErrorCategories()[workers.ErrorCategoryPanic])
+}
+
+func TestWrappedErrorCategory(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, fmt.Errorf("Middleware failed: %w", workers.NewPanicError("task failed"))
+	}))
+
+	waitEvent(t, stops)
+	assert.Equal(t, int64(1), d.ErrorCategories()[workers.ErrorCategoryPanic])
+	assert.Zero(t, d.ErrorCategories()[workers.ErrorCategoryUnknown])
+}
+
+func TestTaskStatusFailByTimeout(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(worker.NewSimpleWorker())
+
+	timedOut := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	timedOut.SetTimeout(time.Millisecond * 50)
+
+	panicked := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		panic("task failed")
+	})
+
+	d.AddTask(timedOut)
+	d.AddTask(panicked)
+
+	statuses := map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		args := waitEvent(t, stops)
+		statuses[args[0].(workers.Task).Id()] = args[1].(workers.Metadata)[workers.TaskMetadataStatus]
+	}
+
+	assert.Equal(t, workers.TaskStatusFailByTimeout, statuses[timedOut.Id()])
+	assert.Equal(t, workers.TaskStatusFail, statuses[panicked.Id()])
+	assert.Equal(t, "FailByTimeout", workers.TaskStatusFailByTimeout.String())
+}
+
+func TestDefaultTaskTimeout(t *testing.T) {
+	testCases := []struct {
+		name           string
+		timeout        time.Duration
+		defaultTimeout time.Duration
+		expected       time.Duration
+	}{
+		{"task timeout", time.Hour, time.Minute, time.Hour},
+		{"default timeout", 0, time.Minute, time.Minute},
+		{"no timeout", 0, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewSimpleDispatcher()
+			d.SetDefaultTaskTimeout(tc.defaultTimeout)
+			assert.Equal(t, tc.defaultTimeout, d.DefaultTaskTimeout())
+
+			runDispatcher(t, d)
+			defer d.Cancel()
+
+			d.AddWorker(worker.NewSimpleWorker())
+
+			remaining := make(chan time.Duration, 1)
+			tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+				var r time.Duration
+				if deadline, ok := ctx.Deadline(); ok {
+					r = time.Until(deadline)
+				}
+
+				remaining <- r
+				return nil, nil
+			})
+			tsk.SetTimeout(tc.timeout)
+			d.AddTask(tsk)
+
+			select {
+			case r := <-remaining:
+				if tc.expected == 0 {
+					assert.Zero(t, r)
+				} else {
+					assert.InDelta(t, float64(tc.expected), float64(r), float64(time.Second))
+				}
+
+			case <-time.After(time.Second * 5):
+				t.Fatal("Task not started")
+			}
+		})
+	}
+}
+
+func TestDefaultTaskTimeoutExpires(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetDefaultTaskTimeout(time.Millisecond * 50)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	hung := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	d.AddTask(hung)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, workers.TaskStatusFailByTimeout, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.ErrorIs(t, args[5].(error), context.DeadlineExceeded)
+}
+
+type traceKey struct{}
+
+func TestContextDecorator(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetContextDecorator(func(ctx context.Context, tsk workers.Task) context.Context {
+		return context.WithValue(ctx, traceKey{}, "trace-"+tsk.Name())
+	})
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		id, _ := workers.TaskIdFromContext(ctx)
+		return []interface{}{ctx.Value(traceKey{}), id}, nil
+	})
+	tsk.SetName("checkout")
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	result, err := d.ExecuteTask(ctx, tsk)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"trace-checkout", tsk.Id()}, result)
+}
+
+func TestAddTaskWithContext(t *testing.T) {
+	d := NewSimpleDispatcher()
+	removes := eventChannel(d, workers.EventTaskRemove)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	finished := make(chan []interface{}, 1)
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		finished <- []interface{}{ctx.Err()}
+		return nil, ctx.Err()
+	})
+	tsk.SetRepeats(-1)
+
+	assert.NoError(t, d.AddTaskWithContext(ctx, tsk))
+	<-started
+
+	// отмена контекста запроса прерывает выполнение и удаляет повторяющуюся задачу
+	ctxCancel()
+	assert.Equal(t, []interface{}{context.Canceled}, waitEvent(t, finished))
+
+	args := waitEvent(t, removes)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusCancel, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.Nil(t, d.GetTaskMetadata(tsk.Id()))
+
+	// уже отменённый контекст не позволяет добавить задачу
+	assert.Equal(t, context.Canceled, d.AddTaskWithContext(ctx, task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})))
+	assert.Empty(t, d.GetTasks())
+}
+
+func TestAddTaskWithContextReleasesWatch(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.NoError(t, d.AddTaskWithContext(ctx, tsk))
+	waitEvent(t, stops)
+
+	// завершённая задача отписывается от долгоживущего контекста
+	assert.Eventually(t, func() bool {
+		watches := 0
+		d.contextWatches.Range(func(interface{}, interface{}) bool {
+			watches++
+			return true
+		})
+
+		return watches == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+type kindsWorker struct {
+	*worker.SimpleWorker
+
+	kinds []string
+}
+
+func (w *kindsWorker) Kinds() []string {
+	return w.kinds
+}
+
+func TestWorkerKinds(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	cpu := &kindsWorker{SimpleWorker: worker.NewSimpleWorker(), kinds: []string{"cpu"}}
+	gpu := &kindsWorker{SimpleWorker: worker.NewSimpleWorker(), kinds: []string{"gpu"}}
+	d.AddWorker(cpu)
+
+	newTask := func(kind string) *task.FunctionTask {
+		tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+			id, _ := workers.WorkerIdFromContext(ctx)
+			return id, nil
+		})
+		tsk.SetKind(kind)
+
+		return tsk
+	}
+
+	// для задачи gpu подходящего воркера пока нет, она остаётся в очереди и не мешает остальным
+	gpuTask := newTask("gpu")
+	d.AddTask(gpuTask)
+	d.AddTask(newTask("cpu"))
+	d.AddTask(newTask(""))
+
+	for i := 0; i < 2; i++ {
+		args := waitEvent(t, stops)
+		assert.NotEqual(t, gpuTask, args[0])
+		assert.Equal(t, cpu.Id(), args[4])
+	}
+
+	assert.Equal(t, workers.TaskStatusWait, d.GetTaskMetadata(gpuTask.Id())[workers.TaskMetadataStatus])
+
+	d.AddWorker(gpu)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, gpuTask, args[0])
+	assert.Equal(t, gpu.Id(), args[4])
+}
+
+func TestTaskRetryEvent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	retries := eventChannel(d, workers.EventTaskRetry)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	failure := errors.New("downstream is broken")
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		if atomic.AddInt64(&runs, 1) <= 2 {
+			return nil, failure
+		}
+
+		return nil, nil
+	})
+	tsk.SetRepeats(4)
+	d.AddTask(tsk)
+
+	// асинхронные события могут прийти в любом порядке
+	attempts := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		args := waitEvent(t, retries)
+		assert.Equal(t, tsk, args[0])
+		assert.IsType(t, time.Time{}, args[3])
+		assert.Equal(t, failure, args[4])
+
+		attempts = append(attempts, args[2].(int64))
+	}
+	assert.ElementsMatch(t, []int64{1, 2}, attempts)
+
+	// успешное выполнение повторяется по расписанию, но повтором после ошибки не считается
+	for i := 0; i < 3; i++ {
+		waitEvent(t, stops)
+	}
+
+	select {
+	case <-retries:
+		t.Fatal("Retry event was fired after success")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestForEachTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	for i := 0; i < 5; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	var visited int
+	d.ForEachTask(func(tsk workers.Task, metadata workers.Metadata) bool {
+		assert.Equal(t, workers.TaskStatusWait, metadata[workers.TaskMetadataStatus])
+		visited++
+		return visited < 3
+	})
+	assert.Equal(t, 3, visited)
+
+	visited = 0
+	d.ForEachWorker(func(workers.Worker, workers.Metadata) bool {
+		visited++
+		return true
+	})
+	assert.Equal(t, 5, visited)
+}
+
+type captureLogger struct {
+	messages chan []interface{}
+}
+
+func (l *captureLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.messages <- append([]interface{}{msg}, keysAndValues...)
+}
+
+func TestLogger(t *testing.T) {
+	d := NewSimpleDispatcher()
+	logger := &captureLogger{messages: make(chan []interface{}, 10)}
+	d.SetLogger(logger)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddTask(task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		workers.OnCleanup(ctx, func() {
+			panic("cleanup failed")
+		})
+
+		return nil, nil
+	}))
+
+	assert.Equal(t, []interface{}{"Task cleanup panic", "panic", "cleanup failed"}, waitEvent(t, logger.messages))
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetMaxConcurrent(3)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	for i := 0; i < 10; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	var running, peak int64
+	for i := 0; i < 10; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			current := atomic.AddInt64(&running, 1)
+			for {
+				last := atomic.LoadInt64(&peak)
+				if current <= last || atomic.CompareAndSwapInt64(&peak, last, current) {
+					break
+				}
+			}
+
+			time.Sleep(time.Millisecond * 20)
+			atomic.AddInt64(&running, -1)
+
+			return nil, nil
+		}))
+	}
+
+	for i := 0; i < 10; i++ {
+		waitEvent(t, stops)
+	}
+
+	assert.Equal(t, int64(3), atomic.LoadInt64(&peak))
+}
+
+func TestResultRetention(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetResultRetention(2, time.Millisecond*300)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	failure := errors.New("downstream is broken")
+	ids := make([]string, 0, 3)
+
+	for i := 0; i < 3; i++ {
+		i := i
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			if i == 2 {
+				return nil, failure
+			}
+
+			return i, nil
+		})
+
+		_, _ = d.ExecuteTask(context.Background(), tsk)
+		ids = append(ids, tsk.Id())
+	}
+
+	// по количеству вытесняется самый старый итог
+	_, _, ok := d.GetTaskResult(ids[0])
+	assert.False(t, ok)
+
+	result, err, ok := d.GetTaskResult(ids[1])
+	assert.True(t, ok)
+	assert.Equal(t, 1, result)
+	assert.NoError(t, err)
+
+	result, err, ok = d.GetTaskResult(ids[2])
+	assert.True(t, ok)
+	assert.Nil(t, result)
+	assert.Equal(t, failure, err)
+
+	// по времени вытесняются все итоги
+	time.Sleep(time.Millisecond * 400)
+
+	for _, id := range ids {
+		_, _, ok = d.GetTaskResult(id)
+		assert.False(t, ok)
+	}
+}
+
+func TestClockInjection(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetRepeats(2)
+	tsk.SetRepeatInterval(time.Minute)
+	d.AddTask(tsk)
+
+	waitEvent(t, starts)
+	waitEvent(t, stops)
+
+	// без движения часов повторный запуск не наступает, сколько бы реального времени ни прошло
+	fc.Increment(time.Minute - time.Second)
+	time.Sleep(time.Millisecond * 200)
+
+	select {
+	case <-starts:
+		t.Fatal("Task started before repeat interval elapsed")
+	default:
+	}
+
+	assert.Eventually(t, func() bool {
+		fc.Increment(time.Second)
+
+		select {
+		case <-starts:
+			return true
+		default:
+			return false
+		}
+	}, time.Second*5, time.Millisecond*50)
+}
+
+func TestDelayedRepeatWakeup(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetRepeats(2)
+	tsk.SetRepeatInterval(time.Second*5 + time.Millisecond*500)
+	d.AddTask(tsk)
+
+	waitEvent(t, starts)
+	waitEvent(t, stops)
+
+	// тики каждую секунду не запускают задачу раньше срока
+	for i := 0; i < 5; i++ {
+		fc.Increment(time.Second)
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	select {
+	case <-starts:
+		t.Fatal("Task started before allow start time")
+	default:
+	}
+
+	// до следующего тика остаётся полсекунды, запуск происходит по пробуждению к сроку задачи
+	fc.Increment(time.Millisecond * 500)
+	waitEvent(t, starts)
+}
+
+type lifecycleWorker struct {
+	*worker.SimpleWorker
+
+	startErr error
+	events   chan string
+}
+
+func (w *lifecycleWorker) OnStart(context.Context) error {
+	w.events <- "start"
+	return w.startErr
+}
+
+func (w *lifecycleWorker) OnStop(context.Context) {
+	w.events <- "stop"
+}
+
+func (w *lifecycleWorker) wait(t *testing.T) string {
+	select {
+	case event := <-w.events:
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("Lifecycle event wasn't fired")
+	}
+
+	return ""
+}
+
+func TestWorkerLifecycle(t *testing.T) {
+	d := NewSimpleDispatcher()
+	done := runDispatcher(t, d)
+
+	failed := &lifecycleWorker{
+		SimpleWorker: worker.NewSimpleWorker(),
+		startErr:     errors.New("database is unavailable"),
+		events:       make(chan string, 10),
+	}
+	assert.Equal(t, failed.startErr, d.AddWorker(failed))
+	assert.Empty(t, d.GetWorkers())
+	assert.Equal(t, "start", failed.wait(t))
+
+	removed := &lifecycleWorker{SimpleWorker: worker.NewSimpleWorker(), events: make(chan string, 10)}
+	assert.NoError(t, d.AddWorker(removed))
+	assert.Equal(t, "start", removed.wait(t))
+
+	d.RemoveWorker(removed)
+	assert.Equal(t, "stop", removed.wait(t))
+
+	// воркер с выполняющейся задачей останавливается при отмене диспетчера после её завершения
+	busy := &lifecycleWorker{SimpleWorker: worker.NewSimpleWorker(), events: make(chan string, 10)}
+	assert.NoError(t, d.AddWorker(busy))
+	assert.Equal(t, "start", busy.wait(t))
+
+	// без жёсткого таймаута диспетчер не дожидается возврата отменённой задачи
+	started := make(chan struct{})
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		busy.events <- "task done"
+		return nil, ctx.Err()
+	})
+	tsk.SetHardTimeout(time.Second * 5)
+	d.AddTask(tsk)
+	<-started
+
+	d.Cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, "task done", busy.wait(t))
+	assert.Equal(t, "stop", busy.wait(t))
+	assert.Empty(t, busy.events)
+	assert.Empty(t, removed.events)
+}
+
+func TestStateSnapshotJSON(t *testing.T) {
+	d := NewSimpleDispatcher()
+	reschedules := eventChannel(d, workers.EventTaskReschedule)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	repeated := make([]workers.Task, 0, 2)
+	for i := 0; i < 2; i++ {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetName(fmt.Sprintf("repeated-%d", i))
+		tsk.SetRepeats(2)
+		tsk.SetRepeatInterval(time.Hour)
+
+		repeated = append(repeated, tsk)
+		d.AddTask(tsk)
+	}
+
+	waitEvent(t, reschedules)
+	waitEvent(t, reschedules)
+
+	delayed := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	delayed.SetName("delayed")
+	delayed.SetStartedAt(time.Now().Add(time.Hour))
+	d.AddTask(delayed)
+
+	data, err := json.Marshal(d.StateSnapshot())
+	assert.NoError(t, err)
+
+	var snapshot workers.DispatcherSnapshot
+	assert.NoError(t, json.Unmarshal(data, &snapshot))
+
+	assert.Equal(t, "Process", snapshot.Status)
+
+	if assert.Len(t, snapshot.Workers, 1) {
+		assert.Equal(t, w.Id(), snapshot.Workers[0].Id)
+		assert.Equal(t, "Wait", snapshot.Workers[0].Status)
+	}
+
+	tasks := map[string]workers.TaskSnapshot{}
+	for _, tsk := range snapshot.Tasks {
+		tasks[tsk.Id] = tsk
+	}
+
+	assert.Len(t, tasks, 3)
+
+	for i, tsk := range repeated {
+		s := tasks[tsk.Id()]
+		assert.Equal(t, fmt.Sprintf("repeated-%d", i), s.Name)
+		assert.Equal(t, "RepeatWait", s.Status)
+		assert.Equal(t, int64(1), s.Attempts)
+
+		if assert.NotNil(t, s.FirstStartedAt) && assert.NotNil(t, s.LastStartedAt) {
+			assert.True(t, s.FirstStartedAt.Equal(*s.LastStartedAt))
+		}
+
+		if assert.NotNil(t, s.AllowStartAt) {
+			assert.WithinDuration(t, time.Now().Add(time.Hour), *s.AllowStartAt, time.Second)
+		}
+	}
+
+	s := tasks[delayed.Id()]
+	assert.Equal(t, "delayed", s.Name)
+	assert.Equal(t, "Wait", s.Status)
+	assert.Equal(t, int64(0), s.Attempts)
+	assert.Nil(t, s.FirstStartedAt)
+	assert.Nil(t, s.LastStartedAt)
+	assert.Contains(t, string(data), `"status":"Wait"`)
+	assert.NotContains(t, string(data), `"first_started_at":null`)
+}
+
+func TestResultCollectors(t *testing.T) {
+	const count = 200
+
+	d := NewSimpleDispatcher()
+	d.SetResultCollectors(4)
+	d.SetResultBufferSize(16)
+	assert.Equal(t, 4, d.ResultCollectors())
+	assert.Equal(t, 16, d.ResultBufferSize())
+
+	var stopped int64
+	d.AddListener(workers.EventTaskExecuteStop, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		atomic.AddInt64(&stopped, 1)
+	}))
+
+	done := runDispatcher(t, d)
+
+	for i := 0; i < 8; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	for i := 0; i < count; i++ {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetRepeats(2)
+		d.AddTask(tsk)
+	}
+
+	// каждая задача выполняется дважды, повторная постановка в очередь идёт из разных сборщиков
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&stopped) == count*2 && len(d.GetTasks()) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	assert.Empty(t, d.GetTasks())
+	assert.Equal(t, map[workers.WorkerStatus]int{workers.WorkerStatusWait: 8}, d.Stats().Workers)
+
+	d.Cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestIsTaskRunning(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	tsk.SetRepeats(2)
+	tsk.SetRepeatInterval(time.Hour)
+
+	assert.False(t, d.IsWorkerBusy(w.Id()))
+	d.AddTask(tsk)
+	<-started
+
+	assert.True(t, d.IsTaskRunning(tsk.Id()))
+	assert.True(t, d.IsWorkerBusy(w.Id()))
+
+	close(release)
+	waitEvent(t, stops)
+
+	assert.Eventually(t, func() bool {
+		return !d.IsTaskRunning(tsk.Id()) && !d.IsWorkerBusy(w.Id())
+	}, time.Second, time.Millisecond*10)
+
+	assert.False(t, d.IsTaskRunning("unknown"))
+	assert.False(t, d.IsWorkerBusy("unknown"))
+}
+
+func TestRequeue(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return atomic.AddInt64(&runs, 1), nil
+	})
+
+	// без хранения итогов задача после завершения недоступна
+	d.AddTask(tsk)
+	waitEvent(t, stops)
+	assert.Eventually(t, func() bool {
+		return d.GetTaskMetadata(tsk.Id()) == nil
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, ErrTaskNotFound, d.Requeue(tsk.Id()))
+
+	d.SetResultRetention(10, time.Minute)
+	d.AddTask(tsk)
+	waitEvent(t, stops)
+
+	assert.Eventually(t, func() bool {
+		_, _, ok := d.GetTaskResult(tsk.Id())
+		return ok
+	}, time.Second, time.Millisecond*10)
+
+	assert.NoError(t, d.Requeue(tsk.Id()))
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, int64(3), args[4])
+	assert.Equal(t, int64(1), args[1].(workers.Metadata)[workers.TaskMetadataAttempts])
+
+	assert.Eventually(t, func() bool {
+		result, _, _ := d.GetTaskResult(tsk.Id())
+		return result == int64(3)
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, ErrTaskNotFound, d.Requeue("unknown"))
+}
+
+func TestTaskStartDelay(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetStartDelay(time.Millisecond * 100)
+
+	addedAt := time.Now()
+	assert.NoError(t, d.AddTask(tsk))
+
+	metadata := d.GetTaskMetadata(tsk.Id())
+	assert.False(t, workers.MetadataTaskAllowStartAt(metadata).Before(addedAt.Add(time.Millisecond*100)))
+
+	waitEvent(t, starts)
+	assert.GreaterOrEqual(t, time.Since(addedAt), time.Millisecond*100)
+}
+
+func TestBroadcastTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+	statuses := eventChannel(d, workers.EventTaskStatusChanged)
+	workerStatuses := eventChannel(d, workers.EventWorkerStatusChanged)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	ids := make([]string, 3)
+	for i := range ids {
+		w := worker.NewSimpleWorker()
+		ids[i] = w.Id()
+		d.AddWorker(w)
+	}
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		workerId, _ := workers.WorkerIdFromContext(ctx)
+		return workerId, nil
+	})
+	assert.NoError(t, d.BroadcastTask(tsk))
+
+	// о запуске и завершении сообщают воркеры первой и последней части
+	args := waitEvent(t, starts)
+	assert.Equal(t, tsk, args[0])
+	assert.Contains(t, ids, args[2].(workers.Worker).Id())
+
+	args = waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusSuccess, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.Contains(t, ids, args[2].(workers.Worker).Id())
+	assert.Nil(t, args[5])
+
+	results := args[4].([]BroadcastResult)
+	assert.Len(t, results, 3)
+
+	executed := make([]string, 0, len(results))
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+		assert.Equal(t, r.WorkerId, r.Result)
+		executed = append(executed, r.WorkerId)
+	}
+
+	assert.ElementsMatch(t, ids, executed)
+
+	// части не порождают собственных событий и не остаются в очереди
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, starts)
+	assert.Empty(t, stops)
+	assert.Empty(t, d.GetTasks())
+
+	for len(statuses) > 0 {
+		assert.Equal(t, tsk, (<-statuses)[0])
+	}
+
+	for len(workerStatuses) > 0 {
+		if current := (<-workerStatuses)[1].(workers.Metadata)[workers.WorkerMetadataTask]; current != nil {
+			assert.Equal(t, tsk, current)
+		}
+	}
+}
+
+func TestBroadcastTaskWorkersChanged(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(worker.NewSimpleWorker())
+
+	blocking := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	d.AddTask(blocking)
+
+	busy := waitEvent(t, starts)[2].(workers.Worker)
+
+	var executed int64
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&executed, 1)
+		return nil, nil
+	})
+	assert.NoError(t, d.BroadcastTask(tsk))
+
+	waitEvent(t, starts)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&executed) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// воркер удалён, не дождавшись своей части, а добавленный позже в проход не попадает
+	d.RemoveWorkerById(busy.Id())
+	d.AddWorker(worker.NewSimpleWorker())
+
+	// остановка отменённой блокирующей задачи может прийти в любом порядке относительно итога
+	args := waitEvent(t, stops)
+	if args[0] == blocking {
+		args = waitEvent(t, stops)
+	}
+
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusFail, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.ErrorIs(t, args[5].(error), ErrBroadcastWorkerRemoved)
+
+	results := args[4].([]BroadcastResult)
+	assert.Len(t, results, 2)
+
+	for _, r := range results {
+		if r.WorkerId == busy.Id() {
+			assert.ErrorIs(t, r.Err, ErrBroadcastWorkerRemoved)
+		} else {
+			assert.NoError(t, r.Err)
+		}
+	}
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&executed))
+}
+
+func TestBroadcastTaskPartCanceled(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	first := worker.NewSimpleWorker()
+	second := worker.NewSimpleWorker()
+	d.AddWorker(first)
+	d.AddWorker(second)
+
+	running := make(chan struct{})
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		if workerId, _ := workers.WorkerIdFromContext(ctx); workerId == first.Id() {
+			close(running)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		return nil, nil
+	})
+	assert.NoError(t, d.BroadcastTask(tsk))
+
+	waitEvent(t, starts)
+	<-running
+
+	// выполняющиеся части не видны в статистике, выполняется только сама задача
+	assert.Equal(t, 1, d.Stats().Tasks[workers.TaskStatusProcess])
+	assert.True(t, d.IsTaskRunning(tsk.Id()))
+
+	// удаление воркера отменяет выполняющуюся часть, и задача завершается, не дожидаясь её возврата
+	d.RemoveWorker(first)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusFail, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+	assert.NotNil(t, args[2])
+	assert.ErrorIs(t, args[5].(error), ErrBroadcastPartCanceled)
+
+	results := args[4].([]BroadcastResult)
+	assert.Len(t, results, 2)
+
+	for _, r := range results {
+		if r.WorkerId == first.Id() {
+			assert.ErrorIs(t, r.Err, ErrBroadcastPartCanceled)
+			assert.False(t, r.Executed)
+		} else {
+			assert.NoError(t, r.Err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, stops)
+	assert.Empty(t, d.GetTasks())
+}
+
+func TestGetTaskInfo(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	_, ok := d.GetTaskInfo("unknown")
+	assert.False(t, ok)
+
+	var (
+		tsk   *task.FunctionTask
+		calls int64
+	)
+
+	tsk = task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			return nil, errors.New("first attempt failed")
+		}
+
+		// после успешной попытки следующая откладывается, чтобы зафиксировать состояние
+		tsk.SetRepeatInterval(time.Hour)
+		return nil, nil
+	})
+	tsk.SetRepeats(3)
+
+	addedAt := time.Now()
+	assert.NoError(t, d.AddTaskWithMetadata(tsk, workers.Metadata{workers.TaskMetadataId: "request-1"}))
+
+	// события асинхронны и могут прийти в любом порядке
+	var failed int
+	for i := 0; i < 2; i++ {
+		if args := waitEvent(t, stops); args[5] != nil {
+			failed++
+		}
+	}
+
+	assert.Equal(t, 1, failed)
+
+	info, ok := d.GetTaskInfo(tsk.Id())
+	assert.True(t, ok)
+	assert.Equal(t, tsk.Id(), info.Id)
+	assert.Equal(t, workers.TaskStatusRepeatWait, info.Status)
+	assert.Equal(t, int64(2), info.Attempts)
+	// до срока следующего запуска задача заблокирована
+	assert.True(t, info.Locked)
+	assert.Nil(t, info.LastHeartbeatAt)
+	assert.Equal(t, "request-1", info.Custom[workers.TaskMetadataId])
+
+	if assert.NotNil(t, info.FirstStartedAt) && assert.NotNil(t, info.LastStartedAt) {
+		assert.False(t, info.FirstStartedAt.Before(addedAt))
+		assert.True(t, info.LastStartedAt.After(*info.FirstStartedAt))
+	}
+
+	if assert.NotNil(t, info.AllowStartAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *info.AllowStartAt, time.Minute)
+	}
+
+	assert.Equal(t, workers.NewTaskInfo(d.GetTaskMetadata(tsk.Id())), info)
+}
+
+func TestWorkerWaitTimeoutRepeatingTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetTickerExecuteTasksDuration(time.Millisecond * 10)
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	repeating := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond * 350)
+		return nil, nil
+	})
+	repeating.SetRepeats(2)
+	repeating.SetPriority(2)
+	repeating.SetWorkerWaitTimeout(time.Millisecond * 300)
+	d.AddTask(repeating)
+
+	waitEvent(t, starts)
+
+	// более приоритетная задача займёт воркер, пока повтор ждёт его меньше таймаута,
+	// хотя с добавления повторяющейся задачи прошло больше
+	urgent := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond * 200)
+		return nil, nil
+	})
+	urgent.SetPriority(1)
+	d.AddTask(urgent)
+
+	for _, expected := range []workers.Task{repeating, urgent, repeating} {
+		args := waitEvent(t, stops)
+		assert.Equal(t, expected, args[0])
+		assert.Nil(t, args[5])
+	}
+}
+
+func TestMaxAttemptsDeadLetter(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetMaxAttempts(3)
+	assert.Equal(t, int64(3), d.MaxAttempts())
+	deadLetters := eventChannel(d, workers.EventTaskDeadLetter)
+
+	handled := make(chan error, 1)
+	d.SetDeadLetterHandler(func(_ workers.Task, err error) {
+		handled <- err
+	})
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	failure := errors.New("always fails")
+	var calls int64
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&calls, 1)
+		return nil, failure
+	})
+	tsk.SetRepeats(-1)
+	d.AddTask(tsk)
+
+	args := waitEvent(t, deadLetters)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, int64(3), args[1].(workers.Metadata)[workers.TaskMetadataAttempts])
+	assert.Equal(t, failure, args[2])
+
+	select {
+	case err := <-handled:
+		assert.Equal(t, failure, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Dead letter handler wasn't called")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&calls))
+	assert.Nil(t, d.GetTaskMetadata(tsk.Id()))
+}
+
+func TestMaxAttemptsSucceedingTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetMaxAttempts(2)
+	deadLetters := eventChannel(d, workers.EventTaskDeadLetter)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetRepeats(-1)
+	d.AddTask(tsk)
+
+	// бесконечная задача без ошибок продолжает выполняться и после исчерпания предела
+	for i := 0; i < 5; i++ {
+		waitEvent(t, stops)
+	}
+
+	assert.Empty(t, deadLetters)
+	assert.NotNil(t, d.GetTaskMetadata(tsk.Id()))
+}
+
+func TestWorkerSelector(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	sticky := worker.NewSimpleWorker()
+	var candidatesCount int64
+
+	d.SetWorkerSelector(WorkerSelectorFunc(func(_ workers.Task, candidates []*manager.WorkersManagerItem) *manager.WorkersManagerItem {
+		atomic.StoreInt64(&candidatesCount, int64(len(candidates)))
+
+		for _, c := range candidates {
+			if c.Id() == sticky.Id() {
+				return c
+			}
+		}
+
+		return nil
+	}))
+	assert.NotNil(t, d.WorkerSelector())
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(sticky)
+	d.AddWorker(worker.NewSimpleWorker())
+
+	for i := 0; i < 3; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+
+		assert.Equal(t, sticky, waitEvent(t, starts)[2])
+		waitEvent(t, stops)
+	}
+
+	assert.Equal(t, int64(3), atomic.LoadInt64(&candidatesCount))
+
+	// невыбранные воркеры вернулись в очередь и без селектора получают задачи
+	d.SetWorkerSelector(nil)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := 0; i < 3; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			<-release
+			return nil, nil
+		}))
+	}
+
+	used := map[workers.Worker]bool{}
+	for i := 0; i < 3; i++ {
+		used[waitEvent(t, starts)[2].(workers.Worker)] = true
+	}
+
+	assert.Len(t, used, 3)
+}
+
+func TestWorkerSelectorBroadcastTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	sticky := worker.NewSimpleWorker()
+	var parts int64
+
+	// селектор отдаёт все задачи одному воркеру, но части рассылки привязаны к своим воркерам
+	d.SetWorkerSelector(WorkerSelectorFunc(func(tsk workers.Task, candidates []*manager.WorkersManagerItem) *manager.WorkersManagerItem {
+		if _, ok := tsk.(*broadcastPart); ok {
+			atomic.AddInt64(&parts, 1)
+		}
+
+		for _, c := range candidates {
+			if c.Id() == sticky.Id() {
+				return c
+			}
+		}
+
+		return nil
+	}))
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(sticky)
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.NoError(t, d.BroadcastTask(tsk))
+
+	for {
+		args := waitEvent(t, stops)
+		if args[0] != tsk {
+			continue
+		}
+
+		assert.Nil(t, args[5])
+		assert.Len(t, args[4].([]BroadcastResult), 3)
+		break
+	}
+
+	assert.Equal(t, int64(0), atomic.LoadInt64(&parts))
+}
+
+func TestRunWithCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d := NewSimpleDispatcherWithContext(ctx)
+	err := d.Run()
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, workers.DispatcherStatusWait, d.Status())
+}
+
+type recordingManager struct {
+	workers.Manager
+
+	pushes int64
+	pulls  int64
+}
+
+func (m *recordingManager) Push(item workers.ManagerItem) error {
+	atomic.AddInt64(&m.pushes, 1)
+	return m.Manager.Push(item)
+}
+
+func (m *recordingManager) Pull() workers.ManagerItem {
+	item := m.Manager.Pull()
+	if item != nil {
+		atomic.AddInt64(&m.pulls, 1)
+	}
+
+	return item
+}
+
+func TestCustomManagers(t *testing.T) {
+	tasks := &recordingManager{Manager: manager.NewTasksManager()}
+	workersManager := &recordingManager{Manager: manager.NewWorkersManager()}
+
+	d := NewSimpleDispatcherWithManagers(context.Background(), tasks, workersManager)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return "done", nil
+	})
+	d.AddTask(tsk)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, "done", args[4])
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&tasks.pushes))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&tasks.pulls))
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&workersManager.pushes), int64(2))
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&workersManager.pulls), int64(1))
+
+	// без своих хранилищ используются стандартные
+	d = NewSimpleDispatcherWithManagers(context.Background(), nil, nil)
+	assert.IsType(t, &manager.TasksManager{}, d.tasks)
+	assert.IsType(t, &manager.WorkersManager{}, d.workers)
+}
+
+func TestWorkerIdleEvent(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	idle := eventChannel(d, workers.EventWorkerIdle)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddWorker(worker.NewSimpleWorker())
+
+	// событие могло сработать и после добавления первого воркера, но свободны все воркеры пула
+	args := waitEvent(t, idle)
+	assert.GreaterOrEqual(t, args[0], 1)
+	assert.Equal(t, args[1], args[0])
+
+	// повторно не раньше интервала
+	d.AddWorker(worker.NewSimpleWorker())
+	time.Sleep(time.Millisecond * 100)
+	assert.Empty(t, idle)
+
+	fc.Increment(poolEventsInterval)
+	d.AddWorker(worker.NewSimpleWorker())
+
+	args = waitEvent(t, idle)
+	assert.GreaterOrEqual(t, args[0], 3)
+	assert.Equal(t, args[1], args[0])
+}
+
+func TestTaskStarvedEvent(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starved := eventChannel(d, workers.EventTaskStarved)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}))
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}))
+
+	args := waitEvent(t, starved)
+	assert.Equal(t, 2, args[0])
+	assert.Equal(t, 0, args[1])
+}
+
+func TestChangeStatus(t *testing.T) {
+	// после отмены Run завершается, и диспетчер возвращается в Wait
+	testCases := []struct {
+		from    workers.DispatcherStatus
+		to      workers.Status
+		legal   bool
+		settled workers.DispatcherStatus
+	}{
+		{workers.DispatcherStatusWait, workers.DispatcherStatusWait, true, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusWait, workers.DispatcherStatusProcess, false, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusWait, workers.DispatcherStatusCancel, false, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusWait, workers.DispatcherStatusPause, false, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusProcess, workers.DispatcherStatusProcess, true, workers.DispatcherStatusProcess},
+		{workers.DispatcherStatusProcess, workers.DispatcherStatusPause, true, workers.DispatcherStatusPause},
+		{workers.DispatcherStatusProcess, workers.DispatcherStatusCancel, true, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusProcess, workers.DispatcherStatusWait, false, workers.DispatcherStatusProcess},
+		{workers.DispatcherStatusProcess, workers.DispatcherStatusShutdown, false, workers.DispatcherStatusProcess},
+		{workers.DispatcherStatusProcess, workers.TaskStatusProcess, false, workers.DispatcherStatusProcess},
+		{workers.DispatcherStatusPause, workers.DispatcherStatusProcess, true, workers.DispatcherStatusProcess},
+		{workers.DispatcherStatusPause, workers.DispatcherStatusCancel, true, workers.DispatcherStatusWait},
+		{workers.DispatcherStatusPause, workers.DispatcherStatusWait, false, workers.DispatcherStatusPause},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.from.String()+" to "+fmt.Sprint(tc.to), func(t *testing.T) {
+			d := NewSimpleDispatcher()
+			defer d.Cancel()
+
+			if tc.from != workers.DispatcherStatusWait {
+				runDispatcher(t, d)
+			}
+
+			if tc.from == workers.DispatcherStatusPause {
+				assert.NoError(t, d.Pause())
+			}
+
+			err := d.ChangeStatus(tc.to)
+			if tc.legal {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrIllegalStatusTransition)
+			}
+
+			assert.Eventually(t, func() bool {
+				return d.Status() == tc.settled
+			}, time.Second, time.Millisecond*10)
+
+			// SetStatus не паникует и при недопустимом переходе
+			assert.NotPanics(t, func() {
+				d.SetStatus(workers.DispatcherStatusShutdown)
+			})
+		})
+	}
+}
+
+func TestTasksByTag(t *testing.T) {
+	d := NewSimpleDispatcher()
+	removes := eventChannel(d, workers.EventTaskRemove)
+
+	newTagged := func(tags map[string]string) *task.FunctionTask {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetTags(tags)
+		d.AddTask(tsk)
+
+		return tsk
+	}
+
+	euReport := newTagged(map[string]string{"region": "eu", "type": "report"})
+	usReport := newTagged(map[string]string{"region": "us", "type": "report"})
+	euExport := newTagged(map[string]string{"region": "eu", "type": "export"})
+
+	assert.ElementsMatch(t, []workers.Task{euReport, usReport}, d.GetTasksByTag("type", "report"))
+	assert.ElementsMatch(t, []workers.Task{euReport, euExport}, d.GetTasksByTag("region", "eu"))
+	assert.Empty(t, d.GetTasksByTag("region", "asia"))
+
+	assert.Equal(t, 2, d.CancelTasksByTag("region", "eu"))
+
+	for i := 0; i < 2; i++ {
+		waitEvent(t, removes)
+	}
+
+	assert.Equal(t, []workers.Task{usReport}, d.GetTasksByTag("type", "report"))
+	assert.Equal(t, []workers.Task{usReport}, d.GetTasks())
+	assert.Equal(t, 0, d.CancelTasksByTag("region", "eu"))
+	assert.Equal(t, 1, d.CancelTasksByTag("type", "report"))
+	assert.Empty(t, d.GetTasks())
+}
+
+func TestExecuteStopExecutedFlag(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	empty := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTask(empty)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, empty, args[0])
+	assert.Nil(t, args[4])
+	assert.Nil(t, args[5])
+	assert.Equal(t, true, args[7])
+	assert.Equal(t, workers.TaskStatusSuccess, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+
+	waitEvent(t, starts)
+
+	// задача вернула пустой итог уже после отмены, успехом это не считается
+	canceled := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, nil
+	})
+	d.AddTask(canceled)
+
+	waitEvent(t, starts)
+	d.Cancel()
+
+	args = waitEvent(t, stops)
+	assert.Equal(t, canceled, args[0])
+	assert.Equal(t, true, args[6])
+	assert.Equal(t, false, args[7])
+	assert.Equal(t, workers.TaskStatusCancel, args[1].(workers.Metadata)[workers.TaskMetadataStatus])
+}
+
+func TestAddWorkerWakesSaturatedQueue(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetTickerExecuteTasksDuration(time.Hour)
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	release := make(chan struct{})
+	defer close(release)
+
+	blocker := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	d.AddTask(blocker)
+	waitEvent(t, starts)
+
+	waiting := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTask(waiting)
+
+	select {
+	case <-starts:
+		t.Fatal("Task started without free worker")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	addedAt := time.Now()
+	d.AddWorker(worker.NewSimpleWorker())
+
+	args := waitEvent(t, starts)
+	assert.Equal(t, waiting, args[0])
+	assert.Less(t, time.Since(addedAt), time.Millisecond*500)
+}
+
+func TestMiddleware(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	var (
+		mutex sync.Mutex
+		calls []string
+	)
+
+	trace := func(name string) Middleware {
+		return func(next workers.RunFunc) workers.RunFunc {
+			return func(ctx context.Context, tsk workers.Task) (interface{}, error) {
+				mutex.Lock()
+				calls = append(calls, name+" before")
+				mutex.Unlock()
+
+				result, err := next(ctx, tsk)
+
+				mutex.Lock()
+				calls = append(calls, name+" after")
+				mutex.Unlock()
+
+				return result, err
+			}
+		}
+	}
+
+	recovery := func(next workers.RunFunc) workers.RunFunc {
+		return func(ctx context.Context, tsk workers.Task) (result interface{}, err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					err = fmt.Errorf("recovered: %v", recovered)
+				}
+			}()
+
+			return next(ctx, tsk)
+		}
+	}
+
+	d.Use(trace("outer"), trace("inner"))
+	d.Use(recovery)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return 42, nil
+	})
+	d.AddTask(tsk)
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, 42, args[4])
+	assert.Nil(t, args[5])
+
+	mutex.Lock()
+	assert.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, calls)
+	mutex.Unlock()
+
+	failed := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		panic("task failed")
+	})
+	d.AddTask(failed)
+
+	args = waitEvent(t, stops)
+	assert.Equal(t, failed, args[0])
+	assert.EqualError(t, args[5].(error), "recovered: task failed")
+
+	var panicErr *workers.PanicError
+	assert.False(t, errors.As(args[5].(error), &panicErr))
+}
+
+func TestAutoscaleTimerOnlyWhenEnabled(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	// без настройки автомасштабирование не держит таймер и не будит диспетчер
+	time.Sleep(time.Millisecond * 100)
+	watchers := fc.WatcherCount()
+
+	assert.NoError(t, d.SetAutoscale(0, 1, func() workers.Worker {
+		return worker.NewSimpleWorker()
+	}))
+	assert.Eventually(t, func() bool {
+		return fc.WatcherCount() == watchers+1
+	}, time.Second*5, time.Millisecond*10)
+
+	assert.NoError(t, d.SetAutoscale(0, 0, nil))
+	assert.Eventually(t, func() bool {
+		return fc.WatcherCount() == watchers
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestAutoscale(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetAutoscaleInterval(time.Millisecond * 20)
+	ups := eventChannel(d, workers.EventWorkerScaleUp)
+	downs := eventChannel(d, workers.EventWorkerScaleDown)
+
+	factory := func() workers.Worker {
+		return worker.NewSimpleWorker()
+	}
+
+	assert.Error(t, d.SetAutoscale(3, 2, factory))
+	assert.NoError(t, d.SetAutoscale(1, 4, factory))
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	// пул дорастает до минимума и без задач
+	args := waitEvent(t, ups)
+	assert.Equal(t, 1, args[1])
+
+	release := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			<-release
+			return nil, nil
+		}))
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(d.GetWorkers()) == 4
+	}, time.Second*5, time.Millisecond*10)
+
+	args = waitEvent(t, ups)
+	assert.LessOrEqual(t, args[1], 4)
+
+	// выполняющиеся задачи не дают сократить пул
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(t, d.GetWorkers(), 4)
+	assert.Equal(t, int64(4), d.Stats().InFlight)
+
+	close(release)
+
+	args = waitEvent(t, downs)
+	assert.GreaterOrEqual(t, args[1], 1)
+
+	assert.Eventually(t, func() bool {
+		return len(d.GetWorkers()) == 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestEventsChannel(t *testing.T) {
+	d := NewSimpleDispatcher()
+	events := d.Events()
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	d.AddTask(tsk)
+
+	var received []workers.Event
+
+	timeout := time.After(time.Second * 5)
+
+	for len(received) < 3 {
+		select {
+		case data, ok := <-events:
+			if !assert.True(t, ok) {
+				return
+			}
+
+			switch data.Event {
+			case workers.EventTaskAdd, workers.EventTaskExecuteStart, workers.EventTaskExecuteStop:
+				assert.Equal(t, tsk, data.Args[0])
+				assert.NotEmpty(t, data.Event.Id())
+				assert.False(t, data.Time.IsZero())
+
+				received = append(received, data.Event)
+			}
+
+		case <-timeout:
+			t.Fatal("Events weren't received")
+		}
+	}
+
+	assert.Equal(t, []workers.Event{workers.EventTaskAdd, workers.EventTaskExecuteStart, workers.EventTaskExecuteStop}, received)
+
+	d.Cancel()
+
+	for range events {
+	}
+}
+
+func TestRemoveWorkerCancelsTask(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	removes := eventChannel(d, workers.EventTaskRemove)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	var runs int64
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt64(&runs, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		return nil, nil
+	})
+	assert.NoError(t, d.AddTask(tsk))
+
+	waitEvent(t, starts)
+	d.RemoveWorker(w)
+
+	// прерванная задача не остаётся в диспетчере в статусе выполнения
+	args := waitEvent(t, removes)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, workers.TaskStatusCancel, workers.MetadataTaskStatus(args[1].(workers.Metadata)))
+	assert.False(t, d.IsTaskRunning(tsk.Id()))
+	assert.Nil(t, d.GetTaskMetadata(tsk.Id()))
+
+	d.AddWorker(worker.NewSimpleWorker())
+	result, err := d.ExecuteTask(context.Background(), tsk)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&runs))
+}
+
+func TestAttemptsAccounting(t *testing.T) {
+	finished := []struct {
+		name   string
+		run    func(context.Context) (interface{}, error)
+		status workers.TaskStatus
+	}{
+		{
+			name: "success",
+			run: func(context.Context) (interface{}, error) {
+				return nil, nil
+			},
+			status: workers.TaskStatusSuccess,
+		},
+		{
+			name: "error",
+			run: func(context.Context) (interface{}, error) {
+				return nil, errors.New("task failed")
+			},
+			status: workers.TaskStatusFail,
+		},
+		{
+			name: "panic",
+			run: func(context.Context) (interface{}, error) {
+				panic("task failed")
+			},
+			status: workers.TaskStatusFail,
+		},
+		{
+			name: "timeout",
+			run: func(ctx context.Context) (interface{}, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			status: workers.TaskStatusFailByTimeout,
+		},
+	}
+
+	for _, tc := range finished {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewSimpleDispatcher()
+			stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+			runDispatcher(t, d)
+			defer d.Cancel()
+
+			d.AddWorker(worker.NewSimpleWorker())
+
+			tsk := task.NewFunctionTask(tc.run)
+			tsk.SetRepeats(3)
+			tsk.SetTimeout(time.Millisecond * 20)
+			d.AddTask(tsk)
+
+			attempts := make([]int64, 0, 3)
+			for i := 0; i < 3; i++ {
+				metadata := waitEvent(t, stops)[1].(workers.Metadata)
+				attempts = append(attempts, workers.MetadataTaskAttempts(metadata))
+
+				// до последней попытки задача уже запланирована повторно
+				if workers.MetadataTaskAttempts(metadata) == 3 {
+					assert.Equal(t, tc.status, workers.MetadataTaskStatus(metadata))
+				} else {
+					assert.Equal(t, workers.TaskStatusRepeatWait, workers.MetadataTaskStatus(metadata))
+				}
+			}
+
+			sort.Slice(attempts, func(i, j int) bool { return attempts[i] < attempts[j] })
+			assert.Equal(t, []int64{1, 2, 3}, attempts)
+
+			// попытки исчерпаны, задача больше не запускается
+			assert.Eventually(t, func() bool {
+				return d.GetTaskMetadata(tsk.Id()) == nil
+			}, time.Second*5, time.Millisecond*10)
+		})
+	}
+
+	t.Run("worker removed", func(t *testing.T) {
+		d := NewSimpleDispatcher()
+		starts := eventChannel(d, workers.EventTaskExecuteStart)
+		stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+		runDispatcher(t, d)
+		defer d.Cancel()
+
+		w := worker.NewSimpleWorker()
+		d.AddWorker(w)
+
+		tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		d.AddTask(tsk)
+
+		waitEvent(t, starts)
+		d.RemoveWorker(w)
+
+		// прерванное выполнение попытку не расходует
+		args := waitEvent(t, stops)
+		assert.Equal(t, true, args[6])
+		assert.Equal(t, int64(0), workers.MetadataTaskAttempts(args[1].(workers.Metadata)))
+	})
+
+	t.Run("dispatcher canceled", func(t *testing.T) {
+		d := NewSimpleDispatcher()
+		starts := eventChannel(d, workers.EventTaskExecuteStart)
+		stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+		runDispatcher(t, d)
+		d.AddWorker(worker.NewSimpleWorker())
+
+		tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		d.AddTask(tsk)
+
+		waitEvent(t, starts)
+		d.Cancel()
+
+		metadata := waitEvent(t, stops)[1].(workers.Metadata)
+		assert.Equal(t, workers.TaskStatusCancel, workers.MetadataTaskStatus(metadata))
+		assert.Equal(t, int64(0), workers.MetadataTaskAttempts(metadata))
+	})
+}
+
+func TestStartStop(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	assert.Error(t, d.Stop(context.Background()))
+
+	d.AddWorker(worker.NewSimpleWorker())
+	assert.NoError(t, d.Start())
+	assert.Equal(t, workers.DispatcherStatusProcess, d.Status())
+	assert.Error(t, d.Start())
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return 42, nil
+	})
+	assert.NoError(t, d.AddTask(tsk))
+
+	args := waitEvent(t, stops)
+	assert.Equal(t, tsk, args[0])
+	assert.Equal(t, 42, args[4])
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	assert.NoError(t, d.Stop(ctx))
+	assert.Equal(t, workers.DispatcherStatusWait, d.Status())
+}
+
+func TestConcurrentStart(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	var (
+		wg      sync.WaitGroup
+		started int64
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if d.Start() == nil {
+				atomic.AddInt64(&started, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&started))
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer ctxCancel()
+
+	assert.NoError(t, d.Stop(ctx))
+}
+
+type retryableError struct {
+	retryable bool
+}
+
+func (e retryableError) Error() string {
+	return fmt.Sprintf("retryable: %v", e.retryable)
+}
+
+func (e retryableError) Retryable() bool {
+	return e.retryable
+}
+
+func TestRetryableErrors(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var transientRuns, permanentRuns int64
+
+	transient := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&transientRuns, 1)
+		return nil, retryableError{retryable: true}
+	})
+	transient.SetRepeats(3)
+
+	// обёрнутая ошибка тоже учитывается
+	permanent := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		atomic.AddInt64(&permanentRuns, 1)
+		return nil, fmt.Errorf("validation: %w", retryableError{retryable: false})
+	})
+	permanent.SetRepeats(3)
+
+	d.AddTask(transient)
+	d.AddTask(permanent)
+
+	for i := 0; i < 4; i++ {
+		args := waitEvent(t, stops)
+		metadata := args[1].(workers.Metadata)
+
+		if args[0] == permanent {
+			assert.Equal(t, workers.TaskStatusFail, workers.MetadataTaskStatus(metadata))
+			assert.Equal(t, int64(1), workers.MetadataTaskAttempts(metadata))
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return d.GetTaskMetadata(transient.Id()) == nil && d.GetTaskMetadata(permanent.Id()) == nil
+	}, time.Second*5, time.Millisecond*10)
+
+	assert.Equal(t, int64(3), atomic.LoadInt64(&transientRuns))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&permanentRuns))
+}
+
+func TestTaskHistory(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetHistorySize(3)
+	d.SetResultRetention(10, time.Minute)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	var runs int64
+	newTask := func() *task.FunctionTask {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			if atomic.AddInt64(&runs, 1)%2 == 0 {
+				return nil, errors.New("flaky")
+			}
+
+			return nil, nil
+		})
+		tsk.SetRepeats(3)
+
+		return tsk
+	}
+
+	tsk := newTask()
+	d.AddTask(tsk)
+
+	for i := 0; i < 3; i++ {
+		waitEvent(t, stops)
+	}
+
+	assert.Eventually(t, func() bool {
+		_, _, ok := d.GetTaskResult(tsk.Id())
+		return ok
+	}, time.Second*5, time.Millisecond*10)
+
+	// история доступна и после завершения задачи
+	history := d.GetTaskHistory(tsk.Id())
+	if assert.Len(t, history, 3) {
+		for i, record := range history {
+			assert.Equal(t, int64(i+1), record.Attempt)
+			assert.False(t, record.StartedAt.IsZero())
+			assert.NotEmpty(t, record.WorkerId)
+		}
+
+		assert.Equal(t, workers.TaskStatusSuccess, history[0].Status)
+		assert.Equal(t, workers.TaskStatusFail, history[1].Status)
+		assert.EqualError(t, history[1].Err, "flaky")
+		assert.Equal(t, workers.TaskStatusSuccess, history[2].Status)
+	}
+
+	// старые записи вытесняются
+	d.SetHistorySize(2)
+
+	evicted := newTask()
+	d.AddTask(evicted)
+
+	for i := 0; i < 3; i++ {
+		waitEvent(t, stops)
+	}
+
+	assert.Eventually(t, func() bool {
+		history := d.GetTaskHistory(evicted.Id())
+		return len(history) == 2 && history[0].Attempt == 2 && history[1].Attempt == 3
+	}, time.Second*5, time.Millisecond*10)
+
+	assert.Nil(t, d.GetTaskHistory("unknown"))
+}
+
+func TestTickerExecuteTasksDisabled(t *testing.T) {
+	fc := fakeclock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewSimpleDispatcherWithClock(context.Background(), fc)
+	d.SetTickerExecuteTasksDuration(0)
+
+	profiler := &countingProfiler{}
+	d.SetProfiler(profiler)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	// единственный проход при запуске
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&profiler.passes) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	time.Sleep(time.Millisecond * 50)
+
+	for i := 0; i < 10; i++ {
+		fc.Increment(time.Second)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&profiler.passes))
+
+	// событие по-прежнему запускает проход
+	d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}))
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&profiler.passes) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	// после включения тикер снова срабатывает
+	d.SetTickerExecuteTasksDuration(time.Second)
+
+	assert.Eventually(t, func() bool {
+		fc.Increment(time.Second)
+		return atomic.LoadInt64(&profiler.passes) > 2
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestTickerExecuteTasksDisabledFallback(t *testing.T) {
+	t.Run("custom manager", func(t *testing.T) {
+		tasks := &recordingManager{Manager: manager.NewTasksManager()}
+		d := NewSimpleDispatcherWithManagers(context.Background(), tasks, nil)
+		d.SetTickerExecuteTasksDuration(0)
+		stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+		runDispatcher(t, d)
+		defer d.Cancel()
+
+		d.AddWorker(worker.NewSimpleWorker())
+
+		// время отложенного запуска у стороннего менеджера неизвестно, задачу подхватывает страховочный проход
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetStartDelay(time.Millisecond * 100)
+		d.AddTask(tsk)
+
+		assert.Equal(t, tsk, waitEvent(t, stops)[0])
+	})
+
+	t.Run("closed gate", func(t *testing.T) {
+		d := NewSimpleDispatcher()
+		d.SetTickerExecuteTasksDuration(0)
+		stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+		runDispatcher(t, d)
+		defer d.Cancel()
+
+		d.AddWorker(worker.NewSimpleWorker())
+
+		var open int32
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetGate(func() bool {
+			return atomic.LoadInt32(&open) == 1
+		})
+		d.AddTask(tsk)
+
+		time.Sleep(time.Millisecond * 50)
+		atomic.StoreInt32(&open, 1)
+
+		assert.Equal(t, tsk, waitEvent(t, stops)[0])
+	})
+}
+
+type fixedIdTask struct {
+	*task.FunctionTask
+
+	id string
+}
+
+func (t *fixedIdTask) Id() string {
+	return t.id
+}
+
+func TestDeduplication(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetDeduplication(true)
+
+	newTask := func() workers.Task {
+		return &fixedIdTask{
+			FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			}),
+			id: "upstream-request",
+		}
+	}
+
+	assert.NoError(t, d.AddTask(newTask()))
+	assert.Equal(t, ErrTaskAlreadyQueued, d.AddTask(newTask()))
+	assert.Len(t, d.GetTasks(), 1)
+
+	// завершённая задача не блокирует повторное добавление
+	d.setStatusTask(d.tasks.GetById("upstream-request"), workers.TaskStatusSuccess)
+	assert.NoError(t, d.AddTask(newTask()))
+
+	d.SetDeduplication(false)
+	assert.Equal(t, ErrDuplicateTaskId, d.AddTask(newTask()))
+}
+
+func TestDuplicateIds(t *testing.T) {
+	d := NewSimpleDispatcher()
+
+	tsk := &fixedIdTask{
+		FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}),
+		id: "shared",
+	}
+	duplicate := &fixedIdTask{
+		FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}),
+		id: "shared",
+	}
+
+	assert.NoError(t, d.AddTask(tsk))
+	assert.Equal(t, ErrDuplicateTaskId, d.AddTask(duplicate))
+	assert.Equal(t, []workers.Task{tsk}, d.GetTasks())
+
+	w := worker.NewSimpleWorker()
+	dup := &lifecycleWorker{SimpleWorker: w, events: make(chan string, 2)}
+
+	assert.NoError(t, d.AddWorker(w))
+	assert.Equal(t, ErrDuplicateWorkerId, d.AddWorker(w))
+	// ресурсы воркера с занятым Id не захватываются
+	assert.Equal(t, ErrDuplicateWorkerId, d.AddWorker(dup))
+	assert.Empty(t, dup.events)
+	assert.Len(t, d.GetWorkers(), 1)
+
+	// после удаления Id снова свободен
+	d.RemoveWorker(w)
+	assert.NoError(t, d.AddWorker(w))
+}
+
+type scheduleTask struct {
+	*task.FunctionTask
+
+	runs chan time.Time
+}
+
+func (t *scheduleTask) NextRunAt(now time.Time) (time.Time, bool) {
+	select {
+	case next := <-t.runs:
+		return next, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestAddTasks(t *testing.T) {
+	d := NewSimpleDispatcher()
+	d.SetDeduplication(true)
+	adds := eventChannel(d, workers.EventTaskAdd)
+
+	newTask := func(id string) workers.Task {
+		return &fixedIdTask{
+			FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			}),
+			id: id,
+		}
+	}
+
+	assert.NoError(t, d.AddTask(newTask("duplicate")))
+	waitEvent(t, adds)
+
+	err := d.AddTasks(newTask("first"), newTask("duplicate"), newTask("second"))
+
+	var tasksErr *workers.TasksError
+	if assert.True(t, errors.As(err, &tasksErr)) {
+		assert.Len(t, tasksErr.Errors, 1)
+		assert.Equal(t, ErrTaskAlreadyQueued, tasksErr.Errors["duplicate"])
+	}
+
+	assert.True(t, errors.Is(err, ErrTaskAlreadyQueued))
+
+	// остальные задачи пакета добавлены
+	assert.Len(t, d.GetTasks(), 3)
+	waitEvent(t, adds)
+	waitEvent(t, adds)
+
+	assert.NoError(t, d.AddTasks())
+}
+
+func TestScheduleNextRun(t *testing.T) {
+	d := NewSimpleDispatcher()
+	reschedules := eventChannel(d, workers.EventTaskReschedule)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	d.AddWorker(worker.NewSimpleWorker())
+
+	tsk := &scheduleTask{
+		FunctionTask: task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}),
+		runs: make(chan time.Time, 1),
+	}
+	tsk.SetRepeats(-1)
+
+	next := time.Now().Add(time.Millisecond * 100)
+	tsk.runs <- next
+	d.AddTask(tsk)
+
+	waitEvent(t, stops)
+	args := waitEvent(t, reschedules)
+	assert.Equal(t, next, args[2])
+	assert.Equal(t, workers.TaskRescheduleReasonSchedule, args[3])
+
+	// расписание исчерпано, после второго запуска задача удаляется
+	waitEvent(t, stops)
+	assert.Eventually(t, func() bool {
+		return len(d.GetTasks()) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+type capacityWorker struct {
+	*worker.SimpleWorker
+
+	capacity int
+}
+
+func (w *capacityWorker) Capacity() int {
+	return w.capacity
+}
+
+func TestWorkerCapacity(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := &capacityWorker{SimpleWorker: worker.NewSimpleWorker(), capacity: 3}
+	d.AddWorker(w)
+
+	for i := 0; i < 4; i++ {
+		d.AddTask(task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, w, waitEvent(t, starts)[2])
+	}
+
+	select {
+	case <-starts:
+		t.Fatal("Task was started over worker capacity")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	assert.Equal(t, int64(3), d.GetWorkerMetadata(w.Id())[workers.WorkerMetadataInFlight])
+
+	d.RemoveWorker(w)
+
+	for i := 0; i < 3; i++ {
+		args := waitEvent(t, stops)
+		assert.Equal(t, context.Canceled, args[5])
+	}
+}
+
+func TestShutdownCancelsTasksOnTimeout(t *testing.T) {
+	d := NewSimpleDispatcher()
+	starts := eventChannel(d, workers.EventTaskExecuteStart)
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	done := runDispatcher(t, d)
+
+	d.AddWorker(worker.NewSimpleWorker())
+	d.AddTask(task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+
+	waitEvent(t, starts)
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer ctxCancel()
+
+	assert.Equal(t, context.DeadlineExceeded, d.Shutdown(ctx))
+	assert.Equal(t, true, waitEvent(t, stops)[6])
+	assert.NoError(t, <-done)
+}
+
+func TestContextIds(t *testing.T) {
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(t, d)
+	defer d.Cancel()
+
+	w := worker.NewSimpleWorker()
+	d.AddWorker(w)
+
+	tsk := task.NewFunctionTask(func(ctx context.Context) (interface{}, error) {
+		taskId, _ := workers.TaskIdFromContext(ctx)
+		workerId, _ := workers.WorkerIdFromContext(ctx)
+
+		return []string{taskId, workerId}, nil
+	})
+	d.AddTask(tsk)
+
+	assert.Equal(t, []string{tsk.Id(), w.Id()}, waitEvent(t, stops)[4])
+}
+
+func BenchmarkRunTaskGoroutines(b *testing.B) {
+	const concurrency = 100
+
+	d := NewSimpleDispatcher()
+	stops := eventChannel(d, workers.EventTaskExecuteStop)
+
+	runDispatcher(b, d)
+	defer d.Cancel()
+
+	for i := 0; i < concurrency; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var started sync.WaitGroup
+		release := make(chan struct{})
+
+		base := runtime.NumGoroutine()
+		started.Add(concurrency)
+
+		for j := 0; j < concurrency; j++ {
+			d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				started.Done()
+				<-release
+				return nil, nil
+			}))
+		}
+
+		started.Wait()
+		b.ReportMetric(float64(runtime.NumGoroutine()-base)/concurrency, "goroutines/task")
+		close(release)
+
+		for j := 0; j < concurrency; j++ {
+			waitEvent(b, stops)
+		}
+	}
+}
+
+func benchmarkDispatcherWithTasks(b *testing.B, count int) *SimpleDispatcher {
+	d := NewSimpleDispatcher()
+
+	for i := 0; i < count; i++ {
+		d.AddTask(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		}))
+	}
+
+	b.ResetTimer()
+	return d
+}
+
+// подсчёт ожидающих задач: через GetTasks метаданные приходится запрашивать отдельно для каждой задачи
+func BenchmarkGetTasks(b *testing.B) {
+	d := benchmarkDispatcherWithTasks(b, 10000)
+
+	for i := 0; i < b.N; i++ {
+		var count int
+
+		for _, tsk := range d.GetTasks() {
+			if d.GetTaskMetadata(tsk.Id())[workers.TaskMetadataStatus] == workers.TaskStatusWait {
+				count++
+			}
+		}
+	}
+}
+
+func BenchmarkForEachTask(b *testing.B) {
+	d := benchmarkDispatcherWithTasks(b, 10000)
+
+	for i := 0; i < b.N; i++ {
+		var count int
+
+		d.ForEachTask(func(_ workers.Task, metadata workers.Metadata) bool {
+			if metadata[workers.TaskMetadataStatus] == workers.TaskStatusWait {
+				count++
+			}
+
+			return true
+		})
+	}
+}
+
+// считает проходы цикла распределения, без воркеров каждый проход завершается через OnNoWorker
+type countingProfiler struct {
+	passes int64
+}
+
+func (p *countingProfiler) OnNoWorker() {
+	atomic.AddInt64(&p.passes, 1)
+}
+
+func (p *countingProfiler) OnNoTask() {}
+
+func (p *countingProfiler) OnDispatch(string, string) {}
+
+func benchmarkAddTasks(b *testing.B, add func(*SimpleDispatcher, []workers.Task)) {
+	const batch = 500
+
+	profiler := &countingProfiler{}
+	d := NewSimpleDispatcher()
+	d.SetProfiler(profiler)
+
+	runDispatcher(b, d)
+	defer d.Cancel()
+
+	var dispatches int64
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+
+		tasks := make([]workers.Task, batch)
+		for j := range tasks {
+			tasks[j] = task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			})
+		}
+
+		passes := atomic.LoadInt64(&profiler.passes)
+
+		b.StartTimer()
+		add(d, tasks)
+		b.StopTimer()
+
+		// ждём, пока цикл распределения отработает пробуждения от пакета
+		time.Sleep(time.Millisecond * 10)
+		dispatches += atomic.LoadInt64(&profiler.passes) - passes
+
+		for _, tsk := range tasks {
+			d.CancelTaskById(tsk.Id())
+		}
+
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(dispatches)/float64(b.N), "dispatches/op")
+}
+
+func BenchmarkAddTaskLoop(b *testing.B) {
+	benchmarkAddTasks(b, func(d *SimpleDispatcher, tasks []workers.Task) {
+		for _, tsk := range tasks {
+			_ = d.AddTask(tsk)
+		}
+	})
+}
+
+func BenchmarkAddTasks(b *testing.B) {
+	benchmarkAddTasks(b, func(d *SimpleDispatcher, tasks []workers.Task) {
+		_ = d.AddTasks(tasks...)
+	})
+}
+
+func benchmarkResultCollectors(b *testing.B, collectors int) {
+	const count = 2000
+
+	d := NewSimpleDispatcher()
+	d.SetResultCollectors(collectors)
+	d.SetResultBufferSize(collectors * 64)
+
+	var stopped int64
+	finished := make(chan struct{}, 1)
+
+	d.AddListener(workers.EventTaskExecuteStop, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		if atomic.AddInt64(&stopped, 1)%count == 0 {
+			finished <- struct{}{}
+		}
+	}))
+
+	runDispatcher(b, d)
+	defer d.Cancel()
+
+	for i := 0; i < runtime.GOMAXPROCS(0)*4; i++ {
+		d.AddWorker(worker.NewSimpleWorker())
+	}
+
+	tasks := make([]workers.Task, count)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := range tasks {
+			tasks[j] = task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			})
+		}
+
+		_ = d.AddTasks(tasks...)
+		<-finished
+	}
+}
+
+func BenchmarkResultCollectorsSingle(b *testing.B) {
+	benchmarkResultCollectors(b, 1)
+}
+
+func BenchmarkResultCollectorsParallel(b *testing.B) {
+	benchmarkResultCollectors(b, 4)
+}
@@ -7,9 +7,9 @@ import (
 	"fmt"
 )
 
-const _DispatcherStatusName = "UndefinedWaitProcessCancel"
+const _DispatcherStatusName = "UndefinedWaitProcessCancelPauseShutdown"
 
-var _DispatcherStatusIndex = [...]uint8{0, 9, 13, 20, 26}
+var _DispatcherStatusIndex = [...]uint8{0, 9, 13, 20, 26, 31, 39}
 
 func (i DispatcherStatus) String() string {
 	if i < 0 || i >= DispatcherStatus(len(_DispatcherStatusIndex)-1) {
@@ -18,13 +18,15 @@ func (i DispatcherStatus) String() string {
 	return _DispatcherStatusName[_DispatcherStatusIndex[i]:_DispatcherStatusIndex[i+1]]
 }
 
-var _DispatcherStatusValues = []DispatcherStatus{0, 1, 2, 3}
+var _DispatcherStatusValues = []DispatcherStatus{0, 1, 2, 3, 4, 5}
 
 var _DispatcherStatusNameToValueMap = map[string]DispatcherStatus{
 	_DispatcherStatusName[0:9]:   0,
 	_DispatcherStatusName[9:13]:  1,
 	_DispatcherStatusName[13:20]: 2,
 	_DispatcherStatusName[20:26]: 3,
+	_DispatcherStatusName[26:31]: 4,
+	_DispatcherStatusName[31:39]: 5,
 }
 
 // DispatcherStatusString retrieves an enum value from the enum constants string name.
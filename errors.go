@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const (
+	ErrorCategoryUnknown = "unknown"
+	ErrorCategoryTimeout = "timeout"
+	ErrorCategoryPanic   = "panic"
+)
+
+// ошибка выполнения задачи с категорией для разбивки статистики отказов
+type ErrorWithCategory interface {
+	error
+
+	Category() string
+}
+
+// ошибка выполнения задачи, сообщающая, имеет ли смысл повторный запуск: при Retryable() == false задача
+// завершается без повторов независимо от оставшихся Repeats, например, при ошибке валидации
+type ErrorWithRetryable interface {
+	error
+
+	Retryable() bool
+}
+
+// паника при выполнении задачи, сохраняет восстановленное значение и стек в момент паники
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func NewPanicError(value interface{}) *PanicError {
+	return &PanicError{
+		Value: value,
+		Stack: debug.Stack(),
+	}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic recovered: %v", e.Value)
+}
+
+func (e *PanicError) Category() string {
+	return ErrorCategoryPanic
+}
+
+// ошибки пакетного добавления задач, ключом служит идентификатор задачи, которую не удалось добавить
+type TasksError struct {
+	Errors map[string]error
+}
+
+func (e *TasksError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	messages := make([]string, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, id+": "+e.Errors[id].Error())
+	}
+
+	return fmt.Sprintf("Failed to add %d tasks: %s", len(ids), strings.Join(messages, "; "))
+}
+
+func (e *TasksError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
@@ -2,25 +2,48 @@ package workers
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/mrsmtvd/go-workers/event"
 )
 
 var (
-	EventAll                     = event.NewBaseEvent("All")
-	EventDispatcherStatusChanged = event.NewBaseEvent("DispatcherStatusChanged")
-	EventWorkerAdd               = event.NewBaseEvent("WorkerAdd")
-	EventWorkerRemove            = event.NewBaseEvent("WorkerRemove")
-	EventWorkerExecuteStart      = event.NewBaseEvent("WorkerExecuteStart")
-	EventWorkerExecuteStop       = event.NewBaseEvent("WorkerExecuteStop")
-	EventWorkerStatusChanged     = event.NewBaseEvent("WorkerStatusChanged")
-	EventTaskAdd                 = event.NewBaseEvent("TaskAdd")
-	EventTaskRemove              = event.NewBaseEvent("TaskRemove")
-	EventTaskExecuteStart        = event.NewBaseEvent("TaskExecuteStart")
-	EventTaskExecuteStop         = event.NewBaseEvent("TaskExecuteStop")
-	EventTaskStatusChanged       = event.NewBaseEvent("TaskStatusChanged")
-	EventListenerAdd             = event.NewBaseEvent("ListenerAdd")
-	EventListenerRemove          = event.NewBaseEvent("ListenerRemove")
+	eventsMutex sync.RWMutex
+	eventsNames = map[string]Event{}
+	eventsList  []Event
+)
+
+var (
+	EventAll                       = RegisterEvent("All")
+	EventDispatcherStatusChanged   = RegisterEvent("DispatcherStatusChanged")
+	EventDispatcherCircuitOpen     = RegisterEvent("DispatcherCircuitOpen")
+	EventDispatcherLifetimeExpired = RegisterEvent("DispatcherLifetimeExpired")
+	EventWorkerAdd                 = RegisterEvent("WorkerAdd")
+	EventWorkerRemove              = RegisterEvent("WorkerRemove")
+	EventWorkerExecuteStart        = RegisterEvent("WorkerExecuteStart")
+	EventWorkerExecuteStop         = RegisterEvent("WorkerExecuteStop")
+	EventWorkerBoostStart          = RegisterEvent("WorkerBoostStart")
+	EventWorkerBoostStop           = RegisterEvent("WorkerBoostStop")
+	EventWorkerStatusChanged       = RegisterEvent("WorkerStatusChanged")
+	EventTaskAdd                   = RegisterEvent("TaskAdd")
+	EventTaskRemove                = RegisterEvent("TaskRemove")
+	EventTaskExecuteStart          = RegisterEvent("TaskExecuteStart")
+	EventTaskExecuteStop           = RegisterEvent("TaskExecuteStop")
+	EventTaskStatusChanged         = RegisterEvent("TaskStatusChanged")
+	EventTaskCacheHit              = RegisterEvent("TaskCacheHit")
+	EventTaskReschedule            = RegisterEvent("TaskReschedule")
+	EventTaskRetry                 = RegisterEvent("TaskRetry")
+	EventTaskStuck                 = RegisterEvent("TaskStuck")
+	EventTaskSkipped               = RegisterEvent("TaskSkipped")
+	EventTaskHeartbeat             = RegisterEvent("TaskHeartbeat")
+	EventTaskDeadLetter            = RegisterEvent("TaskDeadLetter")
+	EventWorkerIdle                = RegisterEvent("WorkerIdle")
+	EventTaskStarved               = RegisterEvent("TaskStarved")
+	EventWorkerScaleUp             = RegisterEvent("WorkerScaleUp")
+	EventWorkerScaleDown           = RegisterEvent("WorkerScaleDown")
+	EventListenerAdd               = RegisterEvent("ListenerAdd")
+	EventListenerRemove            = RegisterEvent("ListenerRemove")
+	EventListenerPanic             = RegisterEvent("ListenerPanic")
 )
 
 type Event interface {
@@ -30,3 +53,36 @@ type Event interface {
 	Id() string
 	Name() string
 }
+
+// регистрирует событие с уникальным именем, при повторной регистрации имени паникует
+func RegisterEvent(name string) Event {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	if _, ok := eventsNames[name]; ok {
+		panic(fmt.Sprintf("Event with name %s already registered", name))
+	}
+
+	e := event.NewBaseEvent(name)
+	eventsNames[name] = e
+	eventsList = append(eventsList, e)
+
+	return e
+}
+
+func GetEventByName(name string) Event {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+
+	return eventsNames[name]
+}
+
+func RegisteredEvents() []Event {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+
+	tmp := make([]Event, len(eventsList))
+	copy(tmp, eventsList)
+
+	return tmp
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"sync/atomic"
+
+	"github.com/pborman/uuid"
+)
+
+var idGenerator atomic.Value
+
+func init() {
+	SetIdGenerator(uuid.New)
+}
+
+// генератор идентификаторов для задач, воркеров и слушателей, по умолчанию UUID
+func SetIdGenerator(generator func() string) {
+	if generator == nil {
+		generator = uuid.New
+	}
+
+	idGenerator.Store(generator)
+}
+
+func NewId() string {
+	return idGenerator.Load().(func() string)()
+}
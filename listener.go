@@ -16,3 +16,35 @@ type ListenerWithEvents interface {
 
 	Events() []Event
 }
+
+type ListenerBatchItem struct {
+	Time time.Time
+	Args []interface{}
+}
+
+// слушатель, получающий события одного типа пачкой, накопленной за окно группировки
+type ListenerWithBatch interface {
+	Listener
+
+	RunBatch(context.Context, Event, []ListenerBatchItem)
+}
+
+// записанное событие с исходными аргументами, достаточными для повторной доставки слушателю
+type EventRecord struct {
+	Event Event
+	Time  time.Time
+	Args  []interface{}
+}
+
+// событие, доставляемое подписчикам канала событий диспетчера, идентификатор события - Event.Id()
+type EventData = EventRecord
+
+// последовательно доставляет записанные события слушателю в исходном порядке и с исходным временем,
+// используется для отладки логики слушателей на захваченных последовательностях событий
+func ReplayEvents(log []EventRecord, into Listener) {
+	ctx := context.Background()
+
+	for _, record := range log {
+		into.Run(ctx, record.Event, record.Time, record.Args...)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"sync/atomic"
 
-	"github.com/pborman/uuid"
+	"github.com/mrsmtvd/go-workers"
 )
 
 type BaseListener struct {
@@ -13,7 +13,7 @@ type BaseListener struct {
 }
 
 func (t *BaseListener) Init() {
-	t.id = uuid.New()
+	t.id = workers.NewId()
 }
 
 func (t *BaseListener) Id() string {
@@ -0,0 +1,44 @@
+package listener
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+)
+
+type FunctionBatchListener struct {
+	BaseListener
+
+	function func(context.Context, workers.Event, []workers.ListenerBatchItem)
+}
+
+func NewFunctionBatchListener(function func(context.Context, workers.Event, []workers.ListenerBatchItem)) *FunctionBatchListener {
+	t := &FunctionBatchListener{
+		function: function,
+	}
+	t.BaseListener.Init()
+
+	return t
+}
+
+func (l *FunctionBatchListener) Run(ctx context.Context, event workers.Event, t time.Time, args ...interface{}) {
+	l.function(ctx, event, []workers.ListenerBatchItem{{
+		Time: t,
+		Args: args,
+	}})
+}
+
+func (l *FunctionBatchListener) RunBatch(ctx context.Context, event workers.Event, items []workers.ListenerBatchItem) {
+	l.function(ctx, event, items)
+}
+
+func (l *FunctionBatchListener) Name() string {
+	n := l.BaseListener.Name()
+
+	if n == "" {
+		return workers.FunctionName(l.function)
+	}
+
+	return n
+}
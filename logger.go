@@ -0,0 +1,15 @@
+package workers
+
+// журнал внутренних ошибок диспетчера, keysAndValues - чередующиеся пары ключ-значение для структурированных логгеров
+type Logger interface {
+	Error(msg string, keysAndValues ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(string, ...interface{}) {}
+
+// логгер, отбрасывающий все сообщения, используется по умолчанию
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
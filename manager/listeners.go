@@ -3,21 +3,41 @@ package manager
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mrsmtvd/go-workers"
 )
 
+// размер очереди событий, ожидающих свободного обработчика при ограниченной параллельности AsyncTrigger
+const listenersAsyncQueueSize = 1024
+
+type loggerHolder struct {
+	logger workers.Logger
+}
+
 type ListenersManager struct {
+	batchWindow  int64
+	asyncDropped int64
+	logger       atomic.Value
+	asyncMutex   sync.RWMutex
+	asyncQueue   chan func()
+	asyncLimit   int
+	asyncStopped bool
+
 	mutex     sync.RWMutex
 	events    map[workers.Event][]*ListenersManagerItem
 	listeners map[string]*ListenersManagerItem
+
+	subscriptionsMutex sync.RWMutex
+	subscriptions      map[*EventsSubscription]struct{}
 }
 
 func NewListenersManager() *ListenersManager {
 	return &ListenersManager{
-		events:    map[workers.Event][]*ListenersManagerItem{},
-		listeners: map[string]*ListenersManagerItem{},
+		events:        map[workers.Event][]*ListenersManagerItem{},
+		listeners:     map[string]*ListenersManagerItem{},
+		subscriptions: map[*EventsSubscription]struct{}{},
 	}
 }
 
@@ -53,6 +73,18 @@ func (m *ListenersManager) Listeners() []workers.Listener {
 	return listeners
 }
 
+func (m *ListenersManager) Subscriptions() map[string][]workers.Event {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	subscriptions := make(map[string][]workers.Event, len(m.listeners))
+	for id, item := range m.listeners {
+		subscriptions[id] = item.Events()
+	}
+
+	return subscriptions
+}
+
 func (m *ListenersManager) GetById(id string) *ListenersManagerItem {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
@@ -74,21 +106,55 @@ func (m *ListenersManager) GetEventById(id string) workers.Event {
 }
 
 func (m *ListenersManager) Attach(event workers.Event, listener workers.Listener) {
+	m.AttachWithPriority(event, listener, 0)
+}
+
+// подписывает слушателя, вызовы которого AsyncTrigger не отбрасывает при переполнении очереди,
+// а выполняет сверх ограничения SetAsyncConcurrency. Нужен для слушателей, которых кто-то ждёт
+func (m *ListenersManager) AttachRequired(event workers.Event, listener workers.Listener) {
+	m.AttachWithPriority(event, listener, 0)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if item, ok := m.listeners[listener.Id()]; ok {
+		item.setRequired()
+	}
+}
+
+// подписывает слушателя на событие с приоритетом: Trigger вызывает слушателей одного события
+// по убыванию приоритета, при равном приоритете в порядке подписки. Порядок гарантируется только
+// для синхронного вызова, подписчики на EventAll вызываются после подписчиков конкретного события,
+// при AsyncTrigger слушатели выполняются параллельно и приоритет не учитывается
+func (m *ListenersManager) AttachWithPriority(event workers.Event, listener workers.Listener, priority int) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	item, ok := m.listeners[listener.Id()]
 	if !ok {
 		item = NewListenersManagerItem(event, listener)
+		item.panicHandler = m.listenerPanic
+		item.SetLogger(m.Logger())
 	}
 	item.AddEvent(event)
+	item.setPriority(event, priority)
 
-	if _, ok := m.events[event]; !ok {
-		m.events[event] = []*ListenersManagerItem{item}
-	} else {
-		m.events[event] = append(m.events[event], item)
+	items := m.events[event]
+	position := len(items)
+
+	for i, exists := range items {
+		if exists.Priority(event) < priority {
+			position = i
+			break
+		}
 	}
 
+	tmp := make([]*ListenersManagerItem, 0, len(items)+1)
+	tmp = append(tmp, items[:position]...)
+	tmp = append(tmp, item)
+	tmp = append(tmp, items[position:]...)
+	m.events[event] = tmp
+
 	m.listeners[listener.Id()] = item
 }
 
@@ -105,68 +171,230 @@ func (m *ListenersManager) DeAttach(event workers.Event, listener workers.Listen
 		return
 	}
 
-	for i := len(m.events[event]) - 1; i >= 0; i-- {
-		if m.events[event][i].Listener() == listener {
-			m.events[event] = append(m.events[event][:i], m.events[event][i+1:]...)
+	// срез мог быть выдан AsyncTrigger, поэтому он не изменяется на месте, а заменяется новым
+	items := m.events[event]
+	tmp := make([]*ListenersManagerItem, 0, len(items))
+
+	for _, exists := range items {
+		if exists.Listener() != listener {
+			tmp = append(tmp, exists)
 		}
 	}
 
+	m.events[event] = tmp
+
 	item.RemoveEvent(event)
 	if len(item.Events()) == 0 {
 		delete(m.listeners, listener.Id())
 	}
 }
 
+// отписывает от события всех слушателей, возвращает отписанных
+func (m *ListenersManager) RemoveAllListeners(event workers.Event) []*ListenersManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.removeAllListeners(event)
+}
+
+// отписывает всех слушателей от всех событий, возвращает отписанных по событиям
+func (m *ListenersManager) ClearListeners() map[workers.Event][]*ListenersManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	removed := make(map[workers.Event][]*ListenersManagerItem, len(m.events))
+	for event := range m.events {
+		if items := m.removeAllListeners(event); len(items) > 0 {
+			removed[event] = items
+		}
+	}
+
+	return removed
+}
+
+func (m *ListenersManager) removeAllListeners(event workers.Event) []*ListenersManagerItem {
+	items := m.events[event]
+	delete(m.events, event)
+
+	for _, item := range items {
+		item.RemoveEvent(event)
+
+		if len(item.Events()) == 0 {
+			delete(m.listeners, item.Id())
+		}
+	}
+
+	return items
+}
+
 func (m *ListenersManager) Trigger(ctx context.Context, event workers.Event, args ...interface{}) {
+	now := time.Now()
+	m.publish(event, now, args)
+
 	listeners := m.listenersForEvent(event)
 	if len(listeners) == 0 {
 		return
 	}
 
-	now := time.Now()
-
 	for _, item := range listeners {
 		item.Fire(ctx, event, now, args...)
 	}
 }
 
 func (m *ListenersManager) AsyncTrigger(ctx context.Context, event workers.Event, args ...interface{}) {
+	now := time.Now()
+	m.publish(event, now, args)
+
 	listeners := m.listenersForEvent(event)
 
 	if len(listeners) == 0 {
 		return
 	}
 
-	now := time.Now()
+	window := m.BatchWindow()
 
 	for _, item := range listeners {
-		go func(i *ListenersManagerItem) {
+		if window > 0 && item.IsBatch() {
+			item.FireBatch(ctx, event, now, window, args...)
+			continue
+		}
+
+		i := item
+		m.runAsync(func() {
 			i.Fire(ctx, event, now, args...)
-		}(item)
+		}, i.IsRequired())
 	}
 }
 
-func (m *ListenersManager) listenersForEvent(event workers.Event) []*ListenersManagerItem {
+// ограничивает количество одновременно выполняемых AsyncTrigger вызовов слушателей, остальные ждут в очереди,
+// а при её переполнении отбрасываются с учётом в DroppedEvents. 0 снимает ограничение, при смене ограничения
+// уже поставленные в очередь вызовы дорабатываются прежними обработчиками
+func (m *ListenersManager) SetAsyncConcurrency(n int) {
+	m.asyncMutex.Lock()
+	defer m.asyncMutex.Unlock()
+
+	m.asyncLimit = n
+	m.restartAsync()
+}
+
+// останавливает обработчики очереди AsyncTrigger, ограничение сохраняется до StartAsync,
+// а вызовы до него выполняются без ограничения
+func (m *ListenersManager) StopAsync() {
+	m.asyncMutex.Lock()
+	defer m.asyncMutex.Unlock()
+
+	m.asyncStopped = true
+	m.restartAsync()
+}
+
+func (m *ListenersManager) StartAsync() {
+	m.asyncMutex.Lock()
+	defer m.asyncMutex.Unlock()
+
+	m.asyncStopped = false
+	m.restartAsync()
+}
+
+func (m *ListenersManager) restartAsync() {
+	if m.asyncQueue != nil {
+		close(m.asyncQueue)
+		m.asyncQueue = nil
+	}
+
+	if m.asyncStopped || m.asyncLimit <= 0 {
+		return
+	}
+
+	queue := make(chan func(), listenersAsyncQueueSize)
+	for i := 0; i < m.asyncLimit; i++ {
+		go func() {
+			for run := range queue {
+				run()
+			}
+		}()
+	}
+
+	m.asyncQueue = queue
+}
+
+// количество вызовов слушателей, отброшенных из-за переполнения очереди AsyncTrigger,
+// вместе с событиями, не поместившимися в буфер подписок EventsOverflowDrop
+func (m *ListenersManager) DroppedEvents() int64 {
+	return atomic.LoadInt64(&m.asyncDropped)
+}
+
+func (m *ListenersManager) runAsync(run func(), required bool) {
+	m.asyncMutex.RLock()
+	defer m.asyncMutex.RUnlock()
+
+	if m.asyncQueue == nil {
+		go run()
+		return
+	}
+
+	select {
+	case m.asyncQueue <- run:
+	default:
+		// без обязательного вызова его ожидающий зависнет, поэтому он выполняется сверх ограничения
+		if required {
+			go run()
+		} else {
+			atomic.AddInt64(&m.asyncDropped, 1)
+		}
+	}
+}
+
+// окно группировки событий для слушателей с пакетной доставкой, 0 отключает группировку
+func (m *ListenersManager) SetBatchWindow(window time.Duration) {
+	atomic.StoreInt64(&m.batchWindow, int64(window))
+}
+
+func (m *ListenersManager) BatchWindow() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.batchWindow))
+}
+
+// логгер паник слушателей, nil отключает журналирование
+func (m *ListenersManager) SetLogger(logger workers.Logger) {
+	m.logger.Store(loggerHolder{logger: logger})
+
 	m.mutex.RLock()
-	listeners := make([]*ListenersManagerItem, 0, len(m.listeners))
-	listenersByEvent, okByEvent := m.events[event]
-	m.mutex.RUnlock()
+	defer m.mutex.RUnlock()
 
-	if okByEvent {
-		listeners = listenersByEvent
+	for _, item := range m.listeners {
+		item.SetLogger(logger)
 	}
+}
 
-	if event == workers.EventAll {
-		return listeners
+func (m *ListenersManager) Logger() workers.Logger {
+	if h, ok := m.logger.Load().(loggerHolder); ok && h.logger != nil {
+		return h.logger
 	}
 
+	return workers.NewNopLogger()
+}
+
+func (m *ListenersManager) listenerPanic(ctx context.Context, item *ListenersManagerItem, event workers.Event, recovered interface{}, stack []byte) {
+	m.Logger().Error("Listener panic", "listener", item.Id(), "event", event.Name(), "panic", recovered, "stack", string(stack))
+
+	// паника в обработчике самого события о панике не должна зацикливать доставку
+	if event != workers.EventListenerPanic {
+		m.AsyncTrigger(ctx, workers.EventListenerPanic, item.Listener(), event, recovered, stack)
+	}
+}
+
+func (m *ListenersManager) listenersForEvent(event workers.Event) []*ListenersManagerItem {
 	m.mutex.RLock()
-	listenersAll, okAll := m.events[workers.EventAll]
+	listenersByEvent := m.events[event]
+	listenersAll := m.events[workers.EventAll]
 	m.mutex.RUnlock()
 
-	if okAll {
-		listeners = append(listeners, listenersAll...)
+	if event == workers.EventAll || len(listenersAll) == 0 {
+		return listenersByEvent
 	}
 
-	return listeners
+	// срезы подписок общие для параллельных вызовов, поэтому дописываются только в новый срез
+	listeners := make([]*ListenersManagerItem, 0, len(listenersByEvent)+len(listenersAll))
+	listeners = append(listeners, listenersByEvent...)
+
+	return append(listeners, listenersAll...)
 }
@@ -2,29 +2,42 @@ package manager
 
 import (
 	"context"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/mrsmtvd/go-workers"
-	"github.com/pborman/uuid"
 )
 
+type listenersBatch struct {
+	ctx   context.Context
+	items []workers.ListenerBatchItem
+}
+
+type listenerPanicHandler func(context.Context, *ListenersManagerItem, workers.Event, interface{}, []byte)
+
 type ListenersManagerItem struct {
-	mutex       sync.RWMutex
-	fires       int64
-	eventAll    bool
-	events      []workers.Event
-	listener    workers.Listener
-	id          string
-	firstFireAt unsafe.Pointer
-	lastFireAt  unsafe.Pointer
+	mutex        sync.RWMutex
+	panicHandler listenerPanicHandler
+	batchMutex   sync.Mutex
+	batches      map[workers.Event]*listenersBatch
+	fires        int64
+	required     uint32
+	eventAll     bool
+	events       []workers.Event
+	priorities   map[workers.Event]int
+	listener     workers.Listener
+	id           string
+	firstFireAt  unsafe.Pointer
+	lastFireAt   unsafe.Pointer
+	logger       atomic.Value
 }
 
 func NewListenersManagerItem(event workers.Event, listener workers.Listener) *ListenersManagerItem {
 	item := &ListenersManagerItem{
-		id:       uuid.New(),
+		id:       workers.NewId(),
 		events:   []workers.Event{},
 		listener: listener,
 	}
@@ -33,6 +46,28 @@ func NewListenersManagerItem(event workers.Event, listener workers.Listener) *Li
 	return item
 }
 
+// логгер паник слушателя вне менеджера, менеджер передаёт свой
+func (l *ListenersManagerItem) SetLogger(logger workers.Logger) {
+	l.logger.Store(loggerHolder{logger: logger})
+}
+
+func (l *ListenersManagerItem) Logger() workers.Logger {
+	if h, ok := l.logger.Load().(loggerHolder); ok && h.logger != nil {
+		return h.logger
+	}
+
+	return workers.NewNopLogger()
+}
+
+// вызовы слушателя не отбрасываются при переполнении очереди AsyncTrigger
+func (l *ListenersManagerItem) IsRequired() bool {
+	return atomic.LoadUint32(&l.required) == 1
+}
+
+func (l *ListenersManagerItem) setRequired() {
+	atomic.StoreUint32(&l.required, 1)
+}
+
 func (l *ListenersManagerItem) Id() string {
 	return l.listener.Id()
 }
@@ -95,6 +130,27 @@ func (l *ListenersManagerItem) RemoveEvent(event workers.Event) {
 			break
 		}
 	}
+
+	delete(l.priorities, event)
+}
+
+// приоритет слушателя при синхронном вызове события
+func (l *ListenersManagerItem) Priority(event workers.Event) int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.priorities[event]
+}
+
+func (l *ListenersManagerItem) setPriority(event workers.Event, priority int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.priorities == nil {
+		l.priorities = map[workers.Event]int{}
+	}
+
+	l.priorities[event] = priority
 }
 
 func (l *ListenersManagerItem) Listener() workers.Listener {
@@ -106,23 +162,97 @@ func (l *ListenersManagerItem) Fire(ctx context.Context, event workers.Event, t
 		return
 	}
 
+	l.fired(1)
+	l.safeRun(ctx, event, func() {
+		l.listener.Run(ctx, event, t, args...)
+	})
+}
+
+func (l *ListenersManagerItem) IsBatch() bool {
+	_, ok := l.listener.(workers.ListenerWithBatch)
+	return ok
+}
+
+// копит событие в пачку, которая будет доставлена слушателю целиком по истечении окна группировки
+func (l *ListenersManagerItem) FireBatch(ctx context.Context, event workers.Event, t time.Time, window time.Duration, args ...interface{}) {
+	if !l.EventIsAllowed(event) {
+		return
+	}
+
+	l.batchMutex.Lock()
+	defer l.batchMutex.Unlock()
+
+	if l.batches == nil {
+		l.batches = map[workers.Event]*listenersBatch{}
+	}
+
+	batch, ok := l.batches[event]
+	if !ok {
+		batch = &listenersBatch{
+			ctx: ctx,
+		}
+		l.batches[event] = batch
+
+		time.AfterFunc(window, func() {
+			l.flushBatch(event)
+		})
+	}
+
+	batch.items = append(batch.items, workers.ListenerBatchItem{
+		Time: t,
+		Args: args,
+	})
+}
+
+func (l *ListenersManagerItem) flushBatch(event workers.Event) {
+	l.batchMutex.Lock()
+	batch, ok := l.batches[event]
+	delete(l.batches, event)
+	l.batchMutex.Unlock()
+
+	if !ok || len(batch.items) == 0 {
+		return
+	}
+
+	l.fired(int64(len(batch.items)))
+	l.safeRun(batch.ctx, event, func() {
+		l.listener.(workers.ListenerWithBatch).RunBatch(batch.ctx, event, batch.items)
+	})
+}
+
+// паника слушателя не должна ронять диспетчер и мешать доставке событий остальным слушателям
+func (l *ListenersManagerItem) safeRun(ctx context.Context, event workers.Event, run func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			stack := debug.Stack()
+
+			if l.panicHandler != nil {
+				l.panicHandler(ctx, l, event, recovered, stack)
+			} else {
+				l.Logger().Error("Listener panic", "listener", l.Id(), "event", event.Name(), "panic", recovered, "stack", string(stack))
+			}
+		}
+	}()
+
+	run()
+}
+
+func (l *ListenersManagerItem) fired(count int64) {
 	now := time.Now()
 
-	atomic.AddInt64(&l.fires, 1)
+	atomic.AddInt64(&l.fires, count)
 	atomic.StorePointer(&l.lastFireAt, unsafe.Pointer(&now))
 
 	if l.FirstFireAt() == nil {
 		atomic.StorePointer(&l.firstFireAt, unsafe.Pointer(&now))
 	}
-
-	l.listener.Run(ctx, event, t, args...)
 }
 
 func (l *ListenersManagerItem) Metadata() workers.Metadata {
 	return workers.Metadata{
 		workers.ListenerMetadataFires:        l.Fires(),
-		workers.ListenerMetadataFirstFiredAt: l.FirstFireAt(),
-		workers.ListenerMetadataLastFireAt:   l.LastFireAt(),
+		workers.ListenerMetadataFirstFiredAt: cloneTime(l.FirstFireAt()),
+		workers.ListenerMetadataLastFireAt:   cloneTime(l.LastFireAt()),
 		workers.ListenerMetadataEvents:       l.Events(),
 	}
 }
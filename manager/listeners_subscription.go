@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+)
+
+// поведение подписки, читатель которой не успевает забирать события
+type EventsOverflowPolicy int
+
+const (
+	// события сверх буфера канала отбрасываются с учётом в DroppedEvents
+	EventsOverflowDrop EventsOverflowPolicy = iota
+	// события копятся в неограниченной очереди подписки до прочтения
+	EventsOverflowBuffer
+)
+
+// подписка на все события менеджера в виде канала, отправка в который никогда не блокирует вызывающего
+type EventsSubscription struct {
+	manager *ListenersManager
+	policy  EventsOverflowPolicy
+
+	mutex     sync.Mutex
+	out       chan workers.EventData
+	queue     []workers.EventData
+	notify    chan struct{}
+	discarded chan struct{}
+	done      chan struct{}
+	closed    bool
+	discard   bool
+}
+
+// подписывает канал с буфером size на все события, порядок событий в канале совпадает с порядком их генерации
+func (m *ListenersManager) Subscribe(policy EventsOverflowPolicy, size int) *EventsSubscription {
+	if size < 0 {
+		size = 0
+	}
+
+	s := &EventsSubscription{
+		manager:   m,
+		policy:    policy,
+		out:       make(chan workers.EventData, size),
+		notify:    make(chan struct{}, 1),
+		discarded: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if policy == EventsOverflowBuffer {
+		go s.pump()
+	}
+
+	m.subscriptionsMutex.Lock()
+	m.subscriptions[s] = struct{}{}
+	m.subscriptionsMutex.Unlock()
+
+	return s
+}
+
+func (s *EventsSubscription) C() <-chan workers.EventData {
+	return s.out
+}
+
+// закрывается, когда подписка перестаёт принимать события
+func (s *EventsSubscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// прекращает приём событий, уже принятые события остаются доступны для чтения, после чего канал закрывается
+func (s *EventsSubscription) Close() {
+	s.close(false)
+}
+
+// прекращает приём событий и закрывает канал, отбрасывая накопленные в очереди события
+func (s *EventsSubscription) Cancel() {
+	s.close(true)
+}
+
+func (s *EventsSubscription) close(discard bool) {
+	s.manager.subscriptionsMutex.Lock()
+	delete(s.manager.subscriptions, s)
+	s.manager.subscriptionsMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if discard && !s.discard {
+		s.discard = true
+		close(s.discarded)
+	}
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	close(s.done)
+
+	if s.policy == EventsOverflowBuffer {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	} else {
+		close(s.out)
+	}
+}
+
+func (s *EventsSubscription) publish(data workers.EventData) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if s.policy == EventsOverflowBuffer {
+		s.queue = append(s.queue, data)
+
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+
+		return
+	}
+
+	select {
+	case s.out <- data:
+	default:
+		atomic.AddInt64(&s.manager.asyncDropped, 1)
+	}
+}
+
+// переносит события из очереди в канал в отдельной горутине, чтобы публикация не ждала читателя
+func (s *EventsSubscription) pump() {
+	defer close(s.out)
+
+	for {
+		s.mutex.Lock()
+
+		if s.discard || (s.closed && len(s.queue) == 0) {
+			s.mutex.Unlock()
+			return
+		}
+
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			<-s.notify
+			continue
+		}
+
+		data := s.queue[0]
+		s.queue[0] = workers.EventData{}
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		select {
+		case s.out <- data:
+		case <-s.discarded:
+			return
+		}
+	}
+}
+
+func (m *ListenersManager) publish(event workers.Event, t time.Time, args []interface{}) {
+	m.subscriptionsMutex.RLock()
+	defer m.subscriptionsMutex.RUnlock()
+
+	if len(m.subscriptions) == 0 {
+		return
+	}
+
+	data := workers.EventData{
+		Event: event,
+		Time:  t,
+		Args:  args,
+	}
+
+	for s := range m.subscriptions {
+		s.publish(data)
+	}
+}
@@ -0,0 +1,337 @@
+package manager
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/listener"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncTriggerBatch(t *testing.T) {
+	m := NewListenersManager()
+	m.SetBatchWindow(time.Millisecond * 50)
+
+	batches := make(chan []workers.ListenerBatchItem, 10)
+	l := listener.NewFunctionBatchListener(func(_ context.Context, _ workers.Event, items []workers.ListenerBatchItem) {
+		batches <- items
+	})
+	m.Attach(workers.EventTaskStatusChanged, l)
+
+	for i := 0; i < 5; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskStatusChanged, i)
+	}
+
+	select {
+	case items := <-batches:
+		if assert.Len(t, items, 5) {
+			for i, item := range items {
+				assert.Equal(t, []interface{}{i}, item.Args)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Batch wasn't delivered")
+	}
+
+	assert.Equal(t, int64(5), m.GetById(l.Id()).Fires())
+}
+
+func TestTriggerPriority(t *testing.T) {
+	m := NewListenersManager()
+	calls := make([]string, 0, 4)
+
+	attach := func(name string, priority int) {
+		m.AttachWithPriority(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+			calls = append(calls, name)
+		}), priority)
+	}
+
+	attach("logging", 0)
+	attach("validation", 10)
+	attach("audit", 0)
+	attach("first", 20)
+
+	m.Trigger(context.Background(), workers.EventTaskAdd)
+	assert.Equal(t, []string{"first", "validation", "logging", "audit"}, calls)
+}
+
+func TestAsyncTriggerPanicIsolation(t *testing.T) {
+	m := NewListenersManager()
+	delivered := make(chan struct{}, 1)
+	panics := make(chan []interface{}, 1)
+
+	bad := listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		panic("listener failed")
+	})
+
+	m.Attach(workers.EventTaskAdd, bad)
+	m.Attach(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		delivered <- struct{}{}
+	}))
+	m.Attach(workers.EventListenerPanic, listener.NewFunctionListener(func(_ context.Context, _ workers.Event, _ time.Time, args ...interface{}) {
+		panics <- args
+	}))
+
+	m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Event wasn't delivered to the remaining listener")
+	}
+
+	select {
+	case args := <-panics:
+		assert.Equal(t, bad.Id(), args[0].(workers.Listener).Id())
+		assert.Equal(t, workers.EventTaskAdd, args[1])
+		assert.Equal(t, "listener failed", args[2])
+	case <-time.After(time.Second):
+		t.Fatal("Panic event wasn't fired")
+	}
+}
+
+type captureLogger struct {
+	messages chan string
+}
+
+func (l *captureLogger) Error(msg string, _ ...interface{}) {
+	l.messages <- msg
+}
+
+func TestListenerPanicLogger(t *testing.T) {
+	logger := &captureLogger{messages: make(chan string, 1)}
+
+	item := NewListenersManagerItem(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		panic("listener failed")
+	}))
+	item.SetLogger(logger)
+
+	// без менеджера паника попадает в логгер слушателя
+	item.Fire(context.Background(), workers.EventTaskAdd, time.Now())
+
+	select {
+	case msg := <-logger.messages:
+		assert.Equal(t, "Listener panic", msg)
+	default:
+		t.Fatal("Panic wasn't logged")
+	}
+}
+
+func TestAsyncConcurrency(t *testing.T) {
+	const concurrency = 2
+
+	m := NewListenersManager()
+	m.SetAsyncConcurrency(concurrency)
+	defer m.SetAsyncConcurrency(0)
+
+	var active, maxActive int64
+
+	started := make(chan struct{}, concurrency)
+	release := make(chan struct{})
+
+	m.Attach(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		current := atomic.AddInt64(&active, 1)
+		for {
+			prev := atomic.LoadInt64(&maxActive)
+			if current <= prev || atomic.CompareAndSwapInt64(&maxActive, prev, current) {
+				break
+			}
+		}
+
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		<-release
+		atomic.AddInt64(&active, -1)
+	}))
+
+	base := runtime.NumGoroutine()
+
+	for i := 0; i < concurrency; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+		<-started
+	}
+
+	// обработчики заняты, поэтому очередь заполняется полностью, а лишние события отбрасываются
+	for i := 0; i < listenersAsyncQueueSize+10; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+	}
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), base)
+	assert.Equal(t, int64(10), m.DroppedEvents())
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&active) == 0 && m.GetById(m.Listeners()[0].Id()).Fires() == listenersAsyncQueueSize+concurrency
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, int64(concurrency), atomic.LoadInt64(&maxActive))
+}
+
+func TestAsyncRequiredListener(t *testing.T) {
+	m := NewListenersManager()
+	m.SetAsyncConcurrency(1)
+	defer m.SetAsyncConcurrency(0)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	m.Attach(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		<-release
+	}))
+
+	m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+	<-started
+
+	for i := 0; i < listenersAsyncQueueSize; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+	}
+
+	// очередь заполнена, обычный слушатель отбрасывается, а обязательный выполняется сверх ограничения
+	fired := make(chan struct{}, 1)
+	m.AttachRequired(workers.EventTaskRemove, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		fired <- struct{}{}
+	}))
+
+	m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+	m.AsyncTrigger(context.Background(), workers.EventTaskRemove)
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Required listener wasn't fired")
+	}
+
+	assert.Equal(t, int64(1), m.DroppedEvents())
+}
+
+func TestStopAsync(t *testing.T) {
+	m := NewListenersManager()
+	m.SetAsyncConcurrency(1)
+	m.StopAsync()
+
+	release := make(chan struct{})
+	var active int64
+
+	m.Attach(workers.EventTaskAdd, listener.NewFunctionListener(func(context.Context, workers.Event, time.Time, ...interface{}) {
+		atomic.AddInt64(&active, 1)
+		<-release
+	}))
+
+	// без обработчиков ограничение не действует
+	for i := 0; i < 3; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskAdd)
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&active) == 3
+	}, time.Second*5, time.Millisecond*10)
+	close(release)
+
+	m.StartAsync()
+	defer m.SetAsyncConcurrency(0)
+
+	assert.Equal(t, 1, m.asyncLimit)
+	assert.NotNil(t, m.asyncQueue)
+}
+
+func TestClearListeners(t *testing.T) {
+	m := NewListenersManager()
+
+	noop := func(context.Context, workers.Event, time.Time, ...interface{}) {}
+	shared := listener.NewFunctionListener(noop)
+	single := listener.NewFunctionListener(noop)
+
+	m.Attach(workers.EventTaskAdd, shared)
+	m.Attach(workers.EventTaskRemove, shared)
+	m.Attach(workers.EventTaskRemove, single)
+
+	// слушатель, подписанный и на другие события, остаётся в менеджере
+	removed := m.RemoveAllListeners(workers.EventTaskAdd)
+	if assert.Len(t, removed, 1) {
+		assert.Equal(t, shared, removed[0].Listener())
+	}
+
+	assert.Equal(t, []workers.Event{workers.EventTaskRemove}, m.GetById(shared.Id()).Events())
+	assert.Empty(t, m.RemoveAllListeners(workers.EventTaskAdd))
+
+	cleared := m.ClearListeners()
+	assert.Len(t, cleared, 1)
+	assert.Len(t, cleared[workers.EventTaskRemove], 2)
+	assert.Empty(t, m.Listeners())
+	assert.Empty(t, m.Subscriptions())
+}
+
+func TestListenersForEventKeepsSharedSlice(t *testing.T) {
+	m := NewListenersManager()
+
+	noop := func(context.Context, workers.Event, time.Time, ...interface{}) {}
+	first := listener.NewFunctionListener(noop)
+	second := listener.NewFunctionListener(noop)
+	all := listener.NewFunctionListener(noop)
+
+	m.Attach(workers.EventTaskAdd, first)
+	m.Attach(workers.EventTaskAdd, second)
+	m.Attach(workers.EventAll, all)
+
+	// после отписки у среза подписок остаётся запас ёмкости
+	m.DeAttach(workers.EventTaskAdd, second)
+
+	shared := m.events[workers.EventTaskAdd]
+	if assert.Len(t, shared, 1) && assert.Greater(t, cap(shared), 1) {
+		listeners := m.listenersForEvent(workers.EventTaskAdd)
+		assert.Len(t, listeners, 2)
+		assert.Nil(t, shared[:cap(shared)][1])
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := NewListenersManager()
+
+	dropped := m.Subscribe(EventsOverflowDrop, 2)
+	buffered := m.Subscribe(EventsOverflowBuffer, 0)
+
+	for i := 0; i < 5; i++ {
+		m.AsyncTrigger(context.Background(), workers.EventTaskStatusChanged, i)
+	}
+
+	assert.Equal(t, int64(3), m.DroppedEvents())
+
+	dropped.Close()
+	buffered.Close()
+
+	var values []interface{}
+	for data := range dropped.C() {
+		values = append(values, data.Args[0])
+	}
+
+	assert.Equal(t, []interface{}{0, 1}, values)
+
+	values = nil
+	for data := range buffered.C() {
+		assert.Equal(t, workers.EventTaskStatusChanged, data.Event)
+		values = append(values, data.Args[0])
+	}
+
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4}, values)
+
+	// после отмены события больше не принимаются
+	canceled := m.Subscribe(EventsOverflowBuffer, 0)
+	canceled.Cancel()
+	m.AsyncTrigger(context.Background(), workers.EventTaskStatusChanged, 5)
+
+	_, ok := <-canceled.C()
+	assert.False(t, ok)
+}
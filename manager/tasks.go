@@ -7,21 +7,52 @@ import (
 	"sync/atomic"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"github.com/mrsmtvd/go-workers"
 )
 
 type TasksManager struct {
 	mutex             sync.Mutex
 	unlockedCounts    uint64
+	tenantsEnabled    uint32
 	queue             *tasksQueue
 	tickerRecalculate *workers.Ticker
+
+	tenantsWeights  map[string]int
+	tenantsInFlight map[string]int64
+	inFlight        map[string]string
+
+	// задачи, выданные через Pull и ещё не вернувшиеся в очередь
+	pulled map[string]*TasksManagerItem
+	// все задачи менеджера по идентификатору, при совпадении Id хранится последняя добавленная
+	items map[string]*TasksManagerItem
+	// задачи по меткам TaskWithTags и метки, с которыми проиндексирована каждая задача
+	tagged    map[taskTag]map[string]*TasksManagerItem
+	itemsTags map[string]map[string]string
+}
+
+type taskTag struct {
+	key   string
+	value string
 }
 
 func NewTasksManager() *TasksManager {
+	return NewTasksManagerWithClock(clock.NewClock())
+}
+
+// менеджер, пересчитывающий доступные задачи по тикам переданных часов
+func NewTasksManagerWithClock(c clock.Clock) *TasksManager {
 	m := &TasksManager{
 		queue:             newTasksQueue(),
 		unlockedCounts:    0,
-		tickerRecalculate: workers.NewTicker(time.Second),
+		tickerRecalculate: workers.NewTickerWithClock(c, time.Second),
+		tenantsWeights:    map[string]int{},
+		tenantsInFlight:   map[string]int64{},
+		inFlight:          map[string]string{},
+		pulled:            map[string]*TasksManagerItem{},
+		items:             map[string]*TasksManagerItem{},
+		tagged:            map[taskTag]map[string]*TasksManagerItem{},
+		itemsTags:         map[string]map[string]string{},
 	}
 
 	// TODO: останавливать рутину после остановки диспетчера
@@ -41,6 +72,15 @@ func (m *TasksManager) Push(task workers.ManagerItem) error {
 	defer m.mutex.Unlock()
 
 	t := task.(*TasksManagerItem)
+	m.releaseTenant(t)
+	m.unpull(t)
+	m.items[t.Id()] = t
+	m.indexTags(t)
+	t.setRemoved(false)
+
+	if taskTenant(t) != "" {
+		atomic.StoreUint32(&m.tenantsEnabled, 1)
+	}
 
 	if t.Index() < 0 {
 		heap.Push(m.queue, t)
@@ -58,12 +98,20 @@ func (m *TasksManager) Pull() workers.ManagerItem {
 		return nil
 	}
 
+	if atomic.LoadUint32(&m.tenantsEnabled) == 1 {
+		return m.pullByTenants()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	item := heap.Pop(m.queue)
 	if item != nil {
-		mItem := item.(workers.ManagerItem)
+		mItem := item.(*TasksManagerItem)
 		mItem.Lock()
 
 		atomic.AddUint64(&m.unlockedCounts, ^uint64(0))
+		m.pulled[mItem.Id()] = mItem
 
 		return mItem
 	}
@@ -76,6 +124,14 @@ func (m *TasksManager) Remove(item workers.ManagerItem) {
 	defer m.mutex.Unlock()
 
 	t := item.(*TasksManagerItem)
+	m.releaseTenant(t)
+	m.unpull(t)
+	t.setRemoved(true)
+
+	if m.items[t.Id()] == t {
+		delete(m.items, t.Id())
+		m.unindexTags(t.Id())
+	}
 
 	i := t.Index()
 	if i >= 0 && i < m.queue.Len() {
@@ -90,16 +146,179 @@ func (m *TasksManager) Remove(item workers.ManagerItem) {
 	}
 }
 
-func (m *TasksManager) GetById(id string) workers.ManagerItem {
+// вес арендатора при распределении воркеров, по умолчанию 1
+func (m *TasksManager) SetTenantWeight(tenant string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tenantsWeights[tenant] = weight
+	atomic.StoreUint32(&m.tenantsEnabled, 1)
+}
+
+func (m *TasksManager) TenantWeight(tenant string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.tenantWeight(tenant)
+}
+
+func (m *TasksManager) TenantInFlight(tenant string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.tenantsInFlight[tenant]
+}
+
+func (m *TasksManager) tenantWeight(tenant string) int {
+	if weight, ok := m.tenantsWeights[tenant]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+// выбирает лучшую доступную задачу арендатора, которому выделено меньше всего воркеров относительно его веса
+func (m *TasksManager) pullByTenants() workers.ManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	candidates := map[string]*TasksManagerItem{}
+
 	for _, t := range m.queue.All() {
-		if t.Id() == id {
-			return t
+		if t.IsLocked() {
+			continue
+		}
+
+		tenant := taskTenant(t)
+		if c, ok := candidates[tenant]; !ok || tasksLess(t, c) {
+			candidates[tenant] = t
 		}
 	}
 
+	var (
+		item  *TasksManagerItem
+		score float64
+	)
+
+	for tenant, t := range candidates {
+		s := float64(m.tenantsInFlight[tenant]) / float64(m.tenantWeight(tenant))
+
+		if item == nil || s < score || (s == score && tasksLess(t, item)) {
+			item = t
+			score = s
+		}
+	}
+
+	if item == nil {
+		return nil
+	}
+
+	heap.Remove(m.queue, item.Index())
+	item.setIndex(-1)
+	item.Lock()
+
+	atomic.AddUint64(&m.unlockedCounts, ^uint64(0))
+
+	m.pulled[item.Id()] = item
+
+	tenant := taskTenant(item)
+	m.inFlight[item.Id()] = tenant
+	m.tenantsInFlight[tenant]++
+
+	return item
+}
+
+func (m *TasksManager) releaseTenant(t *TasksManagerItem) {
+	tenant, ok := m.inFlight[t.Id()]
+	if !ok {
+		return
+	}
+
+	delete(m.inFlight, t.Id())
+
+	if m.tenantsInFlight[tenant]--; m.tenantsInFlight[tenant] <= 0 {
+		delete(m.tenantsInFlight, tenant)
+	}
+}
+
+// задачу с тем же Id могли добавить заново, поэтому из выданных удаляется только сам элемент
+func (m *TasksManager) unpull(t *TasksManagerItem) {
+	if m.pulled[t.Id()] == t {
+		delete(m.pulled, t.Id())
+	}
+}
+
+func (m *TasksManager) GetById(id string) workers.ManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if t, ok := m.items[id]; ok {
+		return t
+	}
+
 	return nil
 }
 
+// задачи, в том числе выполняющиеся, у которых метка key имеет значение value
+func (m *TasksManager) GetByTag(key, value string) []workers.ManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tagged := m.tagged[taskTag{key: key, value: value}]
+	collection := make([]workers.ManagerItem, 0, len(tagged))
+
+	for _, t := range tagged {
+		collection = append(collection, t)
+	}
+
+	return collection
+}
+
+// метки читаются при каждом Push, поэтому изменения меток повторяющейся задачи учитываются со следующего запуска
+func (m *TasksManager) indexTags(t *TasksManagerItem) {
+	m.unindexTags(t.Id())
+
+	task, ok := t.Task().(workers.TaskWithTags)
+	if !ok {
+		return
+	}
+
+	tags := task.Tags()
+	if len(tags) == 0 {
+		return
+	}
+
+	indexed := make(map[string]string, len(tags))
+
+	for key, value := range tags {
+		tag := taskTag{key: key, value: value}
+		if m.tagged[tag] == nil {
+			m.tagged[tag] = map[string]*TasksManagerItem{}
+		}
+
+		m.tagged[tag][t.Id()] = t
+		indexed[key] = value
+	}
+
+	m.itemsTags[t.Id()] = indexed
+}
+
+func (m *TasksManager) unindexTags(id string) {
+	for key, value := range m.itemsTags[id] {
+		tag := taskTag{key: key, value: value}
+
+		if delete(m.tagged[tag], id); len(m.tagged[tag]) == 0 {
+			delete(m.tagged, tag)
+		}
+	}
+
+	delete(m.itemsTags, id)
+}
+
 func (m *TasksManager) GetAll() []workers.ManagerItem {
 	all := m.queue.All()
 	collection := make([]workers.ManagerItem, 0, len(all))
@@ -111,18 +330,74 @@ func (m *TasksManager) GetAll() []workers.ManagerItem {
 	return collection
 }
 
+// обходит задачи в очереди без создания промежуточного среза, fn не должна обращаться к менеджеру
+func (m *TasksManager) ForEach(fn func(workers.ManagerItem) bool) {
+	m.queue.Each(func(t *TasksManagerItem) bool {
+		return fn(t)
+	})
+}
+
+// все задачи вместе с выданными через Pull и ещё не вернувшимися в очередь, снимок согласован с Push и Pull
+func (m *TasksManager) GetAllWithPulled() []workers.ManagerItem {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	all := m.queue.All()
+	collection := make([]workers.ManagerItem, 0, len(all)+len(m.pulled))
+
+	for _, t := range all {
+		collection = append(collection, t)
+	}
+
+	for _, t := range m.pulled {
+		collection = append(collection, t)
+	}
+
+	return collection
+}
+
+// ближайшая дата, с которой станет доступна отложенная задача очереди, ok равен false, если таких задач нет
+func (m *TasksManager) NextAllowStartAt() (next time.Time, ok bool) {
+	m.queue.Each(func(t *TasksManagerItem) bool {
+		if t.ManagerItemBase.IsLocked() || t.IsAllowedStart() {
+			return true
+		}
+
+		if allowStartAt := *t.AllowStartAt(); !ok || allowStartAt.Before(next) {
+			next = allowStartAt
+			ok = true
+		}
+
+		return true
+	})
+
+	return next, ok
+}
+
 // пересчитывает количество не заблокированных задач, так как оно меняется произвольно из-за отложенной даты запуска
+func (m *TasksManager) Recalculate() {
+	var unlockedCounts uint64
+
+	for _, t := range m.queue.All() {
+		if !t.IsLocked() {
+			unlockedCounts++
+		}
+	}
+
+	atomic.StoreUint64(&m.unlockedCounts, unlockedCounts)
+}
+
 func (m *TasksManager) recalculate() {
 	for {
 		<-m.tickerRecalculate.C()
-		var unlockedCounts uint64
-
-		for _, t := range m.queue.All() {
-			if !t.IsLocked() {
-				unlockedCounts++
-			}
-		}
+		m.Recalculate()
+	}
+}
 
-		atomic.StoreUint64(&m.unlockedCounts, unlockedCounts)
+func taskTenant(t *TasksManagerItem) string {
+	if tenant, ok := t.Task().(workers.TaskWithTenant); ok {
+		return tenant.Tenant()
 	}
+
+	return ""
 }
@@ -7,12 +7,14 @@ import (
 	"time"
 	"unsafe"
 
+	"code.cloudfoundry.org/clock"
 	"github.com/mrsmtvd/go-workers"
 )
 
 type TasksManagerItem struct {
 	index    int64
 	attempts int64
+	removed  uint32
 
 	workers.ManagerItemBase
 	mutex sync.RWMutex
@@ -21,16 +23,32 @@ type TasksManagerItem struct {
 	allowStartAt   unsafe.Pointer
 	firstStartedAt unsafe.Pointer
 	lastStartedAt  unsafe.Pointer
+	lastHeartbeat  unsafe.Pointer
+	readySince     unsafe.Pointer
 
-	cancel context.CancelFunc
+	cancel  context.CancelFunc
+	custom  workers.Metadata
+	history []workers.ExecutionRecord
+
+	statusMutex     sync.Mutex
+	statusChangedAt time.Time
+	statusDurations map[workers.TaskStatus]time.Duration
+
+	clock clock.Clock
 }
 
 func NewTasksManagerItem(task workers.Task, status workers.TaskStatus) *TasksManagerItem {
+	return NewTasksManagerItemWithClock(task, status, clock.NewClock())
+}
+
+// элемент, проверяющий разрешённое время запуска по переданным часам
+func NewTasksManagerItemWithClock(task workers.Task, status workers.TaskStatus, c clock.Clock) *TasksManagerItem {
 	item := &TasksManagerItem{
-		task: task,
+		task:  task,
+		clock: c,
 	}
 
-	allowStartAt := time.Now()
+	allowStartAt := c.Now()
 	startedAt := task.StartedAt()
 	if startedAt != nil && startedAt.After(allowStartAt) {
 		allowStartAt = *startedAt
@@ -38,6 +56,7 @@ func NewTasksManagerItem(task workers.Task, status workers.TaskStatus) *TasksMan
 
 	item.setIndex(-1)
 	item.SetAllowStartAt(allowStartAt)
+	item.SetReadySince(allowStartAt)
 	item.SetStatus(status)
 
 	return item
@@ -53,12 +72,16 @@ func (t *TasksManagerItem) Id() string {
 
 func (t *TasksManagerItem) Metadata() workers.Metadata {
 	return workers.Metadata{
-		workers.TaskMetadataStatus:         t.Status(),
-		workers.TaskMetadataAttempts:       t.Attempts(),
-		workers.TaskMetadataAllowStartAt:   t.AllowStartAt(),
-		workers.TaskMetadataFirstStartedAt: t.FirstStartedAt(),
-		workers.TaskMetadataLastStartedAt:  t.LastStartedAt(),
-		workers.TaskMetadataLocked:         t.IsLocked(),
+		workers.TaskMetadataStatus:          t.Status(),
+		workers.TaskMetadataAttempts:        t.Attempts(),
+		workers.TaskMetadataAllowStartAt:    cloneTime(t.AllowStartAt()),
+		workers.TaskMetadataFirstStartedAt:  cloneTime(t.FirstStartedAt()),
+		workers.TaskMetadataLastStartedAt:   cloneTime(t.LastStartedAt()),
+		workers.TaskMetadataLocked:          t.IsLocked(),
+		workers.TaskMetadataStatusDurations: t.StatusDurations(),
+		workers.TaskMetadataLastHeartbeatAt: cloneTime(t.LastHeartbeatAt()),
+		workers.TaskMetadataCustom:          t.CustomMetadata(),
+		workers.TaskMetadataId:              t.Id(),
 	}
 }
 
@@ -80,12 +103,22 @@ func (t *TasksManagerItem) SetAllowStartAt(allowStartedAt time.Time) {
 }
 
 func (t *TasksManagerItem) IsAllowedStart() bool {
-	now := time.Now()
+	now := t.clock.Now()
 	allowStartAt := t.AllowStartAt()
 
 	return allowStartAt.Before(now) || allowStartAt.Equal(now)
 }
 
+// момент, с которого очередной запуск задачи ожидает воркер: добавление или возврат в очередь после выполнения
+func (t *TasksManagerItem) ReadySince() *time.Time {
+	p := atomic.LoadPointer(&t.readySince)
+	return (*time.Time)(p)
+}
+
+func (t *TasksManagerItem) SetReadySince(readySince time.Time) {
+	atomic.StorePointer(&t.readySince, unsafe.Pointer(&readySince))
+}
+
 func (t *TasksManagerItem) FirstStartedAt() *time.Time {
 	p := atomic.LoadPointer(&t.firstStartedAt)
 	return (*time.Time)(p)
@@ -104,6 +137,15 @@ func (t *TasksManagerItem) SetLastStartedAt(lastStartedAt time.Time) {
 	atomic.StorePointer(&t.lastStartedAt, unsafe.Pointer(&lastStartedAt))
 }
 
+func (t *TasksManagerItem) LastHeartbeatAt() *time.Time {
+	p := atomic.LoadPointer(&t.lastHeartbeat)
+	return (*time.Time)(p)
+}
+
+func (t *TasksManagerItem) SetLastHeartbeatAt(lastHeartbeatAt time.Time) {
+	atomic.StorePointer(&t.lastHeartbeat, unsafe.Pointer(&lastHeartbeatAt))
+}
+
 func (t *TasksManagerItem) IsWait() bool {
 	return t.IsStatus(workers.TaskStatusWait) || t.IsStatus(workers.TaskStatusRepeatWait)
 }
@@ -112,6 +154,19 @@ func (t *TasksManagerItem) IsLocked() bool {
 	return t.ManagerItemBase.IsLocked() || !t.IsAllowedStart()
 }
 
+// задача удалена из менеджера, в том числе во время выполнения
+func (t *TasksManagerItem) IsRemoved() bool {
+	return atomic.LoadUint32(&t.removed) == 1
+}
+
+func (t *TasksManagerItem) setRemoved(removed bool) {
+	if removed {
+		atomic.StoreUint32(&t.removed, 1)
+	} else {
+		atomic.StoreUint32(&t.removed, 0)
+	}
+}
+
 func (t *TasksManagerItem) Index() int {
 	return int(atomic.LoadInt64(&t.index))
 }
@@ -124,6 +179,105 @@ func (t *TasksManagerItem) Status() workers.Status {
 	return workers.TaskStatus(t.StatusInt64())
 }
 
+func (t *TasksManagerItem) SetStatus(status workers.Status) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+
+	now := t.clock.Now()
+
+	if !t.statusChangedAt.IsZero() {
+		if t.statusDurations == nil {
+			t.statusDurations = map[workers.TaskStatus]time.Duration{}
+		}
+
+		t.statusDurations[workers.TaskStatus(t.StatusInt64())] += now.Sub(t.statusChangedAt)
+	}
+
+	t.statusChangedAt = now
+	t.ManagerItemBase.SetStatus(status)
+}
+
+// суммарное время, проведённое задачей в каждом из статусов, включая текущий
+func (t *TasksManagerItem) StatusDurations() map[workers.TaskStatus]time.Duration {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+
+	tmp := make(map[workers.TaskStatus]time.Duration, len(t.statusDurations)+1)
+	for status, duration := range t.statusDurations {
+		tmp[status] = duration
+	}
+
+	if !t.statusChangedAt.IsZero() {
+		tmp[workers.TaskStatus(t.StatusInt64())] += t.clock.Since(t.statusChangedAt)
+	}
+
+	return tmp
+}
+
+// произвольные данные вызывающей стороны, хранятся отдельно от служебных ключей
+func (t *TasksManagerItem) CustomMetadata() workers.Metadata {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.custom == nil {
+		return nil
+	}
+
+	tmp := make(workers.Metadata, len(t.custom))
+	for key, value := range t.custom {
+		tmp[key] = value
+	}
+
+	return tmp
+}
+
+func (t *TasksManagerItem) SetCustomMetadata(metadata workers.Metadata) {
+	var tmp workers.Metadata
+
+	if metadata != nil {
+		tmp = make(workers.Metadata, len(metadata))
+		for key, value := range metadata {
+			tmp[key] = value
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.custom = tmp
+}
+
+// добавляет запись о выполнении, оставляя не больше limit последних записей
+func (t *TasksManagerItem) AddExecutionRecord(record workers.ExecutionRecord, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.history) >= limit {
+		t.history = append(t.history[:0], t.history[len(t.history)-limit+1:]...)
+	}
+
+	t.history = append(t.history, record)
+}
+
+// записи о последних выполнениях от старых к новым
+func (t *TasksManagerItem) History() []workers.ExecutionRecord {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if len(t.history) == 0 {
+		return nil
+	}
+
+	tmp := make([]workers.ExecutionRecord, len(t.history))
+	copy(tmp, t.history)
+
+	return tmp
+}
+
 func (t *TasksManagerItem) SetCancel(cancel context.CancelFunc) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
@@ -30,15 +30,7 @@ func (q *tasksQueue) Less(i, j int) bool {
 		return false
 	}
 
-	if q.list[i].IsWait() != q.list[j].IsWait() {
-		return q.list[i].IsWait()
-	}
-
-	if q.list[i].Task().Priority() == q.list[j].Task().Priority() {
-		return q.list[i].AllowStartAt().Before(*q.list[j].AllowStartAt())
-	}
-
-	return q.list[i].Task().Priority() < q.list[j].Task().Priority()
+	return tasksLess(q.list[i], q.list[j])
 }
 
 func (q *tasksQueue) Swap(i, j int) {
@@ -111,3 +103,27 @@ func (q *tasksQueue) All() []*TasksManagerItem {
 
 	return tmp
 }
+
+// обходит задачи очереди под блокировкой на чтение, прекращая обход, когда fn возвращает false
+func (q *tasksQueue) Each(fn func(*TasksManagerItem) bool) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	for _, t := range q.list {
+		if !fn(t) {
+			return
+		}
+	}
+}
+
+func tasksLess(a, b *TasksManagerItem) bool {
+	if a.IsWait() != b.IsWait() {
+		return a.IsWait()
+	}
+
+	if a.Task().Priority() == b.Task().Priority() {
+		return a.AllowStartAt().Before(*b.AllowStartAt())
+	}
+
+	return a.Task().Priority() < b.Task().Priority()
+}
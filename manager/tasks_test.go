@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/mrsmtvd/go-workers"
 	"github.com/mrsmtvd/go-workers/task"
+	"github.com/mrsmtvd/go-workers/worker"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,6 +51,180 @@ func TestPop(t *testing.T) {
 	assert.Len(t, m.GetAll(), 0)
 }
 
+func TestPullSkipsNotAllowedStart(t *testing.T) {
+	m := NewTasksManager()
+
+	delayed := NewTasksManagerItem(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}), workers.TaskStatusRepeatWait)
+	delayed.SetAllowStartAt(time.Now().Add(time.Hour))
+	m.Push(delayed)
+
+	next, ok := m.NextAllowStartAt()
+	assert.True(t, ok)
+	assert.Equal(t, *delayed.AllowStartAt(), next)
+
+	m.Recalculate()
+	assert.Nil(t, m.Pull())
+
+	ready := NewTasksManagerItem(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}), workers.TaskStatusWait)
+	m.Push(ready)
+
+	assert.Equal(t, ready, m.Pull())
+	assert.Nil(t, m.Pull())
+
+	delayed.SetAllowStartAt(time.Now())
+	m.Recalculate()
+
+	_, ok = m.NextAllowStartAt()
+	assert.False(t, ok)
+	assert.Equal(t, delayed, m.Pull())
+}
+
+func TestGetByTag(t *testing.T) {
+	m := NewTasksManager()
+
+	newTagged := func(tags map[string]string) *TasksManagerItem {
+		tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		tsk.SetTags(tags)
+
+		return NewTasksManagerItem(tsk, workers.TaskStatusWait)
+	}
+
+	eu := newTagged(map[string]string{"region": "eu", "type": "report"})
+	us := newTagged(map[string]string{"region": "us", "type": "report"})
+	plain := NewTasksManagerItem(task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	}), workers.TaskStatusWait)
+
+	m.Push(eu)
+	m.Push(us)
+	m.Push(plain)
+
+	assert.ElementsMatch(t, []workers.ManagerItem{eu, us}, m.GetByTag("type", "report"))
+	assert.Equal(t, []workers.ManagerItem{eu}, m.GetByTag("region", "eu"))
+	assert.Empty(t, m.GetByTag("region", "asia"))
+
+	// выданная задача остаётся в индексе, а изменённые метки учитываются при возврате в очередь
+	pulled := m.Pull().(*TasksManagerItem)
+	assert.Len(t, m.GetByTag("type", "report"), 2)
+
+	pulled.Task().(*task.FunctionTask).SetTags(map[string]string{"type": "export"})
+	m.Push(pulled)
+	assert.Equal(t, []workers.ManagerItem{pulled}, m.GetByTag("type", "export"))
+	assert.Len(t, m.GetByTag("type", "report"), 1)
+
+	m.Remove(eu)
+	m.Remove(us)
+	assert.Empty(t, m.GetByTag("type", "report"))
+	assert.Empty(t, m.GetByTag("region", "eu"))
+	assert.Empty(t, m.tagged)
+	assert.Empty(t, m.itemsTags)
+}
+
+func TestPullByTenants(t *testing.T) {
+	m := NewTasksManager()
+	m.SetTenantWeight("a", 2)
+
+	for i := 0; i < 6; i++ {
+		for _, tenant := range []string{"a", "b"} {
+			tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			})
+			tsk.SetTenant(tenant)
+
+			m.Push(NewTasksManagerItem(tsk, workers.TaskStatusWait))
+		}
+	}
+
+	pulled := map[string]int{}
+	for i := 0; i < 6; i++ {
+		item := m.Pull()
+		if assert.NotNil(t, item) {
+			pulled[item.(*TasksManagerItem).Task().(workers.TaskWithTenant).Tenant()]++
+		}
+	}
+
+	assert.Equal(t, 4, pulled["a"])
+	assert.Equal(t, 2, pulled["b"])
+	assert.Equal(t, int64(4), m.TenantInFlight("a"))
+	assert.Equal(t, int64(2), m.TenantInFlight("b"))
+}
+
+func TestPullByTenantsInterleaving(t *testing.T) {
+	m := NewTasksManager()
+	m.SetTenantWeight("a", 1)
+
+	push := func(tenant string, count int) {
+		for i := 0; i < count; i++ {
+			tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+				return nil, nil
+			})
+			tsk.SetTenant(tenant)
+
+			m.Push(NewTasksManagerItem(tsk, workers.TaskStatusWait))
+		}
+	}
+
+	// задачи арендатора a добавлены раньше, но не должны вытеснять b
+	push("a", 10)
+	push("b", 3)
+
+	order := make([]string, 0, 8)
+	for i := 0; i < 8; i++ {
+		item := m.Pull()
+		if assert.NotNil(t, item) {
+			order = append(order, item.(*TasksManagerItem).Task().(workers.TaskWithTenant).Tenant())
+		}
+	}
+
+	assert.Equal(t, []string{"a", "b", "a", "b", "a", "b", "a", "a"}, order)
+}
+
+func TestMetadataAccessors(t *testing.T) {
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+
+	item := NewTasksManagerItem(tsk, workers.TaskStatusWait)
+	item.SetCustomMetadata(workers.Metadata{workers.TaskMetadataStatus: "request-1"})
+	item.SetAttempts(2)
+	item.SetFirstStartedAt(time.Now().Add(-time.Minute))
+	item.SetLastStartedAt(time.Now())
+	item.SetStatus(workers.TaskStatusProcess)
+
+	m := item.Metadata()
+	assert.Equal(t, tsk.Id(), workers.MetadataTaskId(m))
+	assert.Equal(t, workers.TaskStatusProcess, workers.MetadataTaskStatus(m))
+	assert.Equal(t, int64(2), workers.MetadataTaskAttempts(m))
+	assert.Equal(t, *item.AllowStartAt(), *workers.MetadataTaskAllowStartAt(m))
+	assert.Equal(t, *item.FirstStartedAt(), *workers.MetadataTaskFirstStartedAt(m))
+	assert.Equal(t, *item.LastStartedAt(), *workers.MetadataTaskLastStartedAt(m))
+	assert.Nil(t, workers.MetadataTaskLastHeartbeatAt(m))
+	assert.False(t, workers.MetadataTaskLocked(m))
+	assert.Equal(t, "request-1", workers.MetadataTaskCustom(m)[workers.TaskMetadataStatus])
+
+	w := worker.NewSimpleWorker()
+	workerItem := NewWorkersManagerItem(w, workers.WorkerStatusWait)
+	workerItem.SetTask(tsk)
+	workerItem.Acquire()
+
+	m = workerItem.Metadata()
+	assert.Equal(t, w.Id(), workers.MetadataWorkerId(m))
+	assert.Equal(t, workers.WorkerStatusWait, workers.MetadataWorkerStatus(m))
+	assert.Equal(t, tsk, workers.MetadataWorkerTask(m))
+	assert.False(t, workers.MetadataWorkerLocked(m))
+	assert.Equal(t, int64(1), workers.MetadataWorkerInFlight(m))
+
+	// чужие и отсутствующие значения читаются как нулевые
+	assert.Equal(t, int64(0), workers.MetadataTaskAttempts(workers.Metadata{workers.TaskMetadataAttempts: "2"}))
+	assert.Equal(t, "", workers.MetadataTaskId(nil))
+}
+
 func BenchmarkPull(b *testing.B) {
 	m := NewTasksManager()
 
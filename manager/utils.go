@@ -0,0 +1,15 @@
+package manager
+
+import (
+	"time"
+)
+
+// метаданные отдаются наружу, поэтому указатели на внутренние значения заменяются копиями
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	tmp := *t
+	return &tmp
+}
@@ -2,13 +2,14 @@ package manager
 
 import (
 	"errors"
-	"fmt"
 	"sync"
 	"sync/atomic"
 
 	"github.com/mrsmtvd/go-workers"
 )
 
+var ErrDuplicateWorkerId = errors.New("Worker with the same id already exists")
+
 type WorkersManager struct {
 	mutex          sync.RWMutex
 	unlockedCounts uint64
@@ -36,7 +37,7 @@ func (m *WorkersManager) Push(worker workers.ManagerItem) error {
 	m.mutex.RUnlock()
 
 	if ok && exists != worker {
-		return fmt.Errorf("Worker with ID %s already exists", worker.Id())
+		return ErrDuplicateWorkerId
 	}
 
 	worker.Unlock()
@@ -116,3 +117,15 @@ func (m *WorkersManager) GetAll() []workers.ManagerItem {
 
 	return collection
 }
+
+// обходит воркеры без создания промежуточного среза, fn не должна обращаться к менеджеру
+func (m *WorkersManager) ForEach(fn func(workers.ManagerItem) bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, w := range m.workers {
+		if !fn(w) {
+			return
+		}
+	}
+}
@@ -3,22 +3,37 @@ package manager
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 
 	"github.com/mrsmtvd/go-workers"
 )
 
 type WorkersManagerItem struct {
+	inFlight int64
+	retired  uint32
+	stopped  uint32
+	canceled uint32
+
 	workers.ManagerItemBase
 	mutex sync.RWMutex
 
-	worker workers.Worker
-	task   workers.Task
-	cancel context.CancelFunc
+	worker      workers.Worker
+	task        workers.Task
+	cancel      context.CancelFunc
+	cancels     map[string]context.CancelFunc
+	retiredDone chan struct{}
 }
 
+const (
+	workerActive uint32 = iota
+	workerRetiring
+	workerRetired
+)
+
 func NewWorkersManagerItem(worker workers.Worker, status workers.WorkerStatus) *WorkersManagerItem {
 	item := &WorkersManagerItem{
-		worker: worker,
+		worker:      worker,
+		retiredDone: make(chan struct{}),
 	}
 	item.SetStatus(status)
 
@@ -35,9 +50,11 @@ func (w *WorkersManagerItem) Id() string {
 
 func (w *WorkersManagerItem) Metadata() workers.Metadata {
 	return workers.Metadata{
-		workers.WorkerMetadataStatus: w.Status(),
-		workers.WorkerMetadataTask:   w.Task(),
-		workers.WorkerMetadataLocked: w.IsLocked(),
+		workers.WorkerMetadataStatus:   w.Status(),
+		workers.WorkerMetadataTask:     w.Task(),
+		workers.WorkerMetadataLocked:   w.IsLocked(),
+		workers.WorkerMetadataInFlight: w.InFlight(),
+		workers.WorkerMetadataId:       w.Id(),
 	}
 }
 
@@ -66,12 +83,101 @@ func (w *WorkersManagerItem) SetCancel(cancel context.CancelFunc) {
 	w.cancel = cancel
 }
 
+// функция отмены конкретной выполняемой задачи, nil удаляет её, у отменённого воркера задача отменяется сразу
+func (w *WorkersManagerItem) SetTaskCancel(id string, cancel context.CancelFunc) {
+	w.mutex.Lock()
+
+	if cancel == nil {
+		delete(w.cancels, id)
+		w.mutex.Unlock()
+		return
+	}
+
+	if w.IsCanceled() {
+		w.mutex.Unlock()
+		cancel()
+		return
+	}
+
+	defer w.mutex.Unlock()
+
+	if w.cancels == nil {
+		w.cancels = map[string]context.CancelFunc{}
+	}
+
+	w.cancels[id] = cancel
+}
+
+// отменяет все выполняемые воркером задачи, в том числе те, функция отмены которых будет установлена позже
 func (w *WorkersManagerItem) Cancel() {
-	w.mutex.RLock()
-	cancel := w.cancel
-	w.mutex.RUnlock()
+	w.mutex.Lock()
+	atomic.StoreUint32(&w.canceled, 1)
+	cancels := make([]context.CancelFunc, 0, len(w.cancels)+1)
+	if w.cancel != nil {
+		cancels = append(cancels, w.cancel)
+	}
+	for _, cancel := range w.cancels {
+		cancels = append(cancels, cancel)
+	}
+	w.mutex.Unlock()
 
-	if cancel != nil {
+	for _, cancel := range cancels {
 		cancel()
 	}
 }
+
+func (w *WorkersManagerItem) IsCanceled() bool {
+	return atomic.LoadUint32(&w.canceled) == 1
+}
+
+// сколько задач воркер может выполнять одновременно
+func (w *WorkersManagerItem) Capacity() int {
+	if c, ok := w.worker.(workers.WorkerWithCapacity); ok && c.Capacity() > 1 {
+		return c.Capacity()
+	}
+
+	return 1
+}
+
+func (w *WorkersManagerItem) InFlight() int64 {
+	return atomic.LoadInt64(&w.inFlight)
+}
+
+// учитывает выданную воркеру задачу и возвращает количество выполняемых задач
+func (w *WorkersManagerItem) Acquire() int64 {
+	return atomic.AddInt64(&w.inFlight, 1)
+}
+
+// учитывает завершение задачи и возвращает количество оставшихся
+func (w *WorkersManagerItem) Release() int64 {
+	return atomic.AddInt64(&w.inFlight, -1)
+}
+
+// воркер выводится из пула: новые задачи ему не выдаются, после текущей задачи он удаляется
+func (w *WorkersManagerItem) IsRetired() bool {
+	return atomic.LoadUint32(&w.retired) != workerActive
+}
+
+func (w *WorkersManagerItem) Retire() {
+	atomic.CompareAndSwapUint32(&w.retired, workerActive, workerRetiring)
+}
+
+// отмечает завершение вывода из пула, true возвращается только первому вызвавшему
+func (w *WorkersManagerItem) CompleteRetire() bool {
+	if !atomic.CompareAndSwapUint32(&w.retired, workerRetiring, workerRetired) {
+		return false
+	}
+
+	close(w.retiredDone)
+	return true
+}
+
+// отмечает остановку воркера, true возвращается только первому вызвавшему
+func (w *WorkersManagerItem) CompleteStop() bool {
+	return atomic.CompareAndSwapUint32(&w.stopped, 0, 1)
+}
+
+// закрывается, когда выведенный из пула воркер удалён
+func (w *WorkersManagerItem) RetiredDone() <-chan struct{} {
+	return w.retiredDone
+}
@@ -1,15 +1,24 @@
 package workers
 
+import (
+	"time"
+)
+
 type MetadataKey int64
 
 const (
 	DispatcherMetadataStatus MetadataKey = iota
+	DispatcherMetadataAbandonedTasks
+	DispatcherMetadataErrorCategories
+	DispatcherMetadataTasksStatusDurations
 )
 
 const (
 	WorkerMetadataStatus MetadataKey = iota
 	WorkerMetadataTask
 	WorkerMetadataLocked
+	WorkerMetadataInFlight
+	WorkerMetadataId
 )
 
 const (
@@ -19,6 +28,10 @@ const (
 	TaskMetadataFirstStartedAt
 	TaskMetadataLastStartedAt
 	TaskMetadataLocked
+	TaskMetadataStatusDurations
+	TaskMetadataLastHeartbeatAt
+	TaskMetadataCustom
+	TaskMetadataId
 )
 
 const (
@@ -29,3 +42,117 @@ const (
 )
 
 type Metadata map[MetadataKey]interface{}
+
+// метаданные задачи в виде структуры, времена равны nil, пока соответствующее событие не наступило
+type TaskInfo struct {
+	Id              string
+	Status          TaskStatus
+	Attempts        int64
+	AllowStartAt    *time.Time
+	FirstStartedAt  *time.Time
+	LastStartedAt   *time.Time
+	LastHeartbeatAt *time.Time
+	Locked          bool
+	Custom          Metadata
+}
+
+// запись об одном выполнении задачи на воркере
+type ExecutionRecord struct {
+	Attempt   int64
+	WorkerId  string
+	StartedAt time.Time
+	Duration  time.Duration
+	Status    TaskStatus
+	Err       error
+}
+
+func NewTaskInfo(m Metadata) TaskInfo {
+	return TaskInfo{
+		Id:              MetadataTaskId(m),
+		Status:          MetadataTaskStatus(m),
+		Attempts:        MetadataTaskAttempts(m),
+		AllowStartAt:    MetadataTaskAllowStartAt(m),
+		FirstStartedAt:  MetadataTaskFirstStartedAt(m),
+		LastStartedAt:   MetadataTaskLastStartedAt(m),
+		LastHeartbeatAt: MetadataTaskLastHeartbeatAt(m),
+		Locked:          MetadataTaskLocked(m),
+		Custom:          MetadataTaskCustom(m),
+	}
+}
+
+// типизированное чтение метаданных задачи и воркера, при отсутствии ключа или другом типе значения
+// возвращается нулевое значение
+
+func MetadataTaskId(m Metadata) string {
+	id, _ := m[TaskMetadataId].(string)
+	return id
+}
+
+func MetadataTaskStatus(m Metadata) TaskStatus {
+	status, _ := m[TaskMetadataStatus].(TaskStatus)
+	return status
+}
+
+// количество израсходованных попыток: попытка расходуется, когда RunTask вернулся сам (успехом, ошибкой
+// или паникой), по истечении таймаута задачи и при выдаче результата из кеша. Отменённое выполнение, в том
+// числе прерванное удалением воркера или остановкой диспетчера, попытку не расходует. Задача, прерванная
+// удалением воркера, завершается статусом отмены и может быть добавлена заново
+func MetadataTaskAttempts(m Metadata) int64 {
+	attempts, _ := m[TaskMetadataAttempts].(int64)
+	return attempts
+}
+
+func MetadataTaskAllowStartAt(m Metadata) *time.Time {
+	t, _ := m[TaskMetadataAllowStartAt].(*time.Time)
+	return t
+}
+
+func MetadataTaskFirstStartedAt(m Metadata) *time.Time {
+	t, _ := m[TaskMetadataFirstStartedAt].(*time.Time)
+	return t
+}
+
+func MetadataTaskLastStartedAt(m Metadata) *time.Time {
+	t, _ := m[TaskMetadataLastStartedAt].(*time.Time)
+	return t
+}
+
+func MetadataTaskLastHeartbeatAt(m Metadata) *time.Time {
+	t, _ := m[TaskMetadataLastHeartbeatAt].(*time.Time)
+	return t
+}
+
+func MetadataTaskLocked(m Metadata) bool {
+	locked, _ := m[TaskMetadataLocked].(bool)
+	return locked
+}
+
+func MetadataTaskCustom(m Metadata) Metadata {
+	custom, _ := m[TaskMetadataCustom].(Metadata)
+	return custom
+}
+
+func MetadataWorkerId(m Metadata) string {
+	id, _ := m[WorkerMetadataId].(string)
+	return id
+}
+
+func MetadataWorkerStatus(m Metadata) WorkerStatus {
+	status, _ := m[WorkerMetadataStatus].(WorkerStatus)
+	return status
+}
+
+func MetadataWorkerTask(m Metadata) Task {
+	task, _ := m[WorkerMetadataTask].(Task)
+	return task
+}
+
+func MetadataWorkerLocked(m Metadata) bool {
+	locked, _ := m[WorkerMetadataLocked].(bool)
+	return locked
+}
+
+func MetadataWorkerInFlight(m Metadata) int64 {
+	inFlight, _ := m[WorkerMetadataInFlight].(int64)
+	return inFlight
+}
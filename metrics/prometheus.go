@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/listener"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultSuccess = "success"
+	resultFail    = "fail"
+)
+
+// слушатель, экспортирующий метрики выполнения задач в Prometheus,
+// счётчики потокобезопасны, поэтому слушатель можно вызывать из AsyncTrigger
+type PrometheusListener struct {
+	listener.BaseListener
+
+	taskDuration   *prometheus.HistogramVec
+	tasksTotal     *prometheus.CounterVec
+	workersProcess prometheus.Gauge
+}
+
+func NewPrometheusListener(registerer prometheus.Registerer) *PrometheusListener {
+	l := &PrometheusListener{
+		taskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "workers",
+			Name:      "task_duration_seconds",
+			Help:      "Duration of task executions",
+		}, []string{"name"}),
+		tasksTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "workers",
+			Name:      "tasks_total",
+			Help:      "Number of finished task executions by result",
+		}, []string{"name", "result"}),
+		workersProcess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "workers",
+			Name:      "workers_process",
+			Help:      "Number of workers executing a task",
+		}),
+	}
+	l.BaseListener.Init()
+	l.SetName("prometheus")
+
+	registerer.MustRegister(l.taskDuration, l.tasksTotal, l.workersProcess)
+
+	return l
+}
+
+func (l *PrometheusListener) Events() []workers.Event {
+	return []workers.Event{
+		workers.EventTaskExecuteStop,
+		workers.EventWorkerStatusChanged,
+	}
+}
+
+func (l *PrometheusListener) Run(_ context.Context, event workers.Event, t time.Time, args ...interface{}) {
+	switch event {
+	case workers.EventTaskExecuteStop:
+		l.taskExecuteStop(t, args...)
+
+	case workers.EventWorkerStatusChanged:
+		l.workerStatusChanged(args...)
+	}
+}
+
+func (l *PrometheusListener) taskExecuteStop(t time.Time, args ...interface{}) {
+	if len(args) < 7 {
+		return
+	}
+
+	task, ok := args[0].(workers.Task)
+	if !ok {
+		return
+	}
+
+	// отменённые выполнения не относятся ни к успешным, ни к провалившимся
+	if cancel, _ := args[6].(bool); cancel {
+		return
+	}
+
+	name := task.Name()
+
+	if metadata, ok := args[1].(workers.Metadata); ok {
+		if startedAt, ok := metadata[workers.TaskMetadataLastStartedAt].(*time.Time); ok && startedAt != nil {
+			l.taskDuration.WithLabelValues(name).Observe(t.Sub(*startedAt).Seconds())
+		}
+	}
+
+	if err, _ := args[5].(error); err != nil {
+		l.tasksTotal.WithLabelValues(name, resultFail).Inc()
+	} else {
+		l.tasksTotal.WithLabelValues(name, resultSuccess).Inc()
+	}
+}
+
+func (l *PrometheusListener) workerStatusChanged(args ...interface{}) {
+	if len(args) < 4 {
+		return
+	}
+
+	status, last := args[2], args[3]
+
+	switch {
+	case status == workers.WorkerStatusProcess && last != workers.WorkerStatusProcess:
+		l.workersProcess.Inc()
+	case status != workers.WorkerStatusProcess && last == workers.WorkerStatusProcess:
+		l.workersProcess.Dec()
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrsmtvd/go-workers"
+	"github.com/mrsmtvd/go-workers/task"
+	"github.com/mrsmtvd/go-workers/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusListener(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	l := NewPrometheusListener(registry)
+	ctx := context.Background()
+
+	tsk := task.NewFunctionTask(func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	tsk.SetName("sync")
+
+	w := worker.NewSimpleWorker()
+	startedAt := time.Now()
+	metadata := workers.Metadata{workers.TaskMetadataLastStartedAt: &startedAt}
+
+	l.Run(ctx, workers.EventWorkerStatusChanged, startedAt, w, workers.Metadata{}, workers.WorkerStatusProcess, workers.WorkerStatusWait)
+	l.Run(ctx, workers.EventWorkerStatusChanged, startedAt, w, workers.Metadata{}, workers.WorkerStatusProcess, workers.WorkerStatusWait)
+	l.Run(ctx, workers.EventWorkerStatusChanged, startedAt, w, workers.Metadata{}, workers.WorkerStatusWait, workers.WorkerStatusProcess)
+
+	stoppedAt := startedAt.Add(time.Second)
+	l.Run(ctx, workers.EventTaskExecuteStop, stoppedAt, tsk, metadata, w, workers.Metadata{}, nil, nil, false)
+	l.Run(ctx, workers.EventTaskExecuteStop, stoppedAt, tsk, metadata, w, workers.Metadata{}, nil, errors.New("failed"), false)
+	l.Run(ctx, workers.EventTaskExecuteStop, stoppedAt, tsk, metadata, w, workers.Metadata{}, nil, context.Canceled, true)
+
+	expected := `
+# HELP workers_tasks_total Number of finished task executions by result
+# TYPE workers_tasks_total counter
+workers_tasks_total{name="sync",result="fail"} 1
+workers_tasks_total{name="sync",result="success"} 1
+# HELP workers_workers_process Number of workers executing a task
+# TYPE workers_workers_process gauge
+workers_workers_process 1
+`
+
+	assert.NoError(t, testutil.CollectAndCompare(registry, strings.NewReader(expected), "workers_tasks_total", "workers_workers_process"))
+	assert.Equal(t, 1, testutil.CollectAndCount(registry, "workers_task_duration_seconds"))
+}
@@ -0,0 +1,9 @@
+package workers
+
+// точки принятия решений цикла распределения задач, позволяют понять,
+// во что упирается пул: в нехватку воркеров или в отсутствие задач
+type Profiler interface {
+	OnNoWorker()
+	OnNoTask()
+	OnDispatch(taskId, workerId string)
+}
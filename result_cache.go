@@ -0,0 +1,11 @@
+package workers
+
+import (
+	"time"
+)
+
+// хранилище результатов задач с TaskWithCache
+type ResultCache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{}, ttl time.Duration)
+}
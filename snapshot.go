@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"time"
+)
+
+type WorkerSnapshot struct {
+	Id        string    `json:"id"`
+	Status    string    `json:"status"`
+	Task      string    `json:"task,omitempty"`
+	Locked    bool      `json:"locked"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WorkerPoolSnapshot struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Workers   []WorkerSnapshot `json:"workers"`
+}
+
+type WorkerSnapshotChange struct {
+	Before WorkerSnapshot `json:"before"`
+	After  WorkerSnapshot `json:"after"`
+}
+
+type WorkerPoolDiff struct {
+	Added   []WorkerSnapshot       `json:"added"`
+	Removed []WorkerSnapshot       `json:"removed"`
+	Changed []WorkerSnapshotChange `json:"changed"`
+}
+
+// сравнивает два снимка пула воркеров, изменёнными считаются воркеры с другим статусом или задачей
+func DiffWorkerSnapshots(a, b WorkerPoolSnapshot) WorkerPoolDiff {
+	diff := WorkerPoolDiff{}
+	before := make(map[string]WorkerSnapshot, len(a.Workers))
+
+	for _, w := range a.Workers {
+		before[w.Id] = w
+	}
+
+	for _, w := range b.Workers {
+		prev, ok := before[w.Id]
+		if !ok {
+			diff.Added = append(diff.Added, w)
+			continue
+		}
+
+		delete(before, w.Id)
+
+		if prev.Status != w.Status || prev.Task != w.Task {
+			diff.Changed = append(diff.Changed, WorkerSnapshotChange{
+				Before: prev,
+				After:  w,
+			})
+		}
+	}
+
+	for _, w := range a.Workers {
+		if _, ok := before[w.Id]; ok {
+			diff.Removed = append(diff.Removed, w)
+		}
+	}
+
+	return diff
+}
+
+type TaskSnapshot struct {
+	Id             string     `json:"id"`
+	Name           string     `json:"name"`
+	Status         string     `json:"status"`
+	Attempts       int64      `json:"attempts"`
+	FirstStartedAt *time.Time `json:"first_started_at,omitempty"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	AllowStartAt   *time.Time `json:"allow_start_at,omitempty"`
+}
+
+// полное состояние диспетчера для отладочных выгрузок, статусы представлены строковыми именами
+type DispatcherSnapshot struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Status    string           `json:"status"`
+	Tasks     []TaskSnapshot   `json:"tasks"`
+	Workers   []WorkerSnapshot `json:"workers"`
+}
+
+type DispatcherStats struct {
+	CreatedAt time.Time            `json:"created_at"`
+	Tasks     map[TaskStatus]int   `json:"tasks"`
+	Workers   map[WorkerStatus]int `json:"workers"`
+	InFlight  int64                `json:"in_flight"`
+	Delayed   int                  `json:"delayed"`
+}
@@ -25,3 +25,7 @@ func (i *StatusItemBase) SetStatus(status Status) {
 func (i *StatusItemBase) IsStatus(status Status) bool {
 	return i.StatusInt64() == status.Int64()
 }
+
+func (i *StatusItemBase) CompareAndSwapStatus(old, new Status) bool {
+	return atomic.CompareAndSwapInt64(&i.status, old.Int64(), new.Int64())
+}
@@ -7,6 +7,14 @@ import (
 
 type TaskStatus int64
 
+// причины повторного планирования задачи, передаются в EventTaskReschedule
+const (
+	TaskRescheduleReasonImmediate = "immediate"
+	TaskRescheduleReasonInterval  = "interval"
+	TaskRescheduleReasonBackoff   = "backoff"
+	TaskRescheduleReasonSchedule  = "schedule"
+)
+
 const (
 	TaskStatusUndefined TaskStatus = iota
 	TaskStatusWait
@@ -15,6 +23,8 @@ const (
 	TaskStatusFail
 	TaskStatusRepeatWait
 	TaskStatusCancel
+	TaskStatusNoWorker
+	TaskStatusFailByTimeout
 )
 
 func (i TaskStatus) Int64() int64 {
@@ -40,3 +50,89 @@ type Task interface {
 	// для отложенного запуска
 	StartedAt() *time.Time
 }
+
+type TaskWithHardTimeout interface {
+	Task
+
+	// сколько ждать завершения задачи после истечения таймаута, прежде чем бросить её горутину,
+	// брошенная горутина продолжает работать и потреблять ресурсы до возврата из Run, а функции
+	// очистки выполняются только после этого возврата. При 0 итог выполнения ждёт возврата из Run
+	HardTimeout() time.Duration
+}
+
+type TaskWithDeadline interface {
+	Task
+
+	// абсолютный срок завершения независимо от ожидания в очереди, действует вместе с Timeout по более раннему из них,
+	// задача, срок которой истёк до запуска, не выполняется и завершается со статусом FailByTimeout, нулевое значение отключает срок
+	Deadline() time.Time
+}
+
+type TaskWithBackoff interface {
+	Task
+
+	// интервал до следующего запуска, используется вместо RepeatInterval, attempt - номер завершившейся попытки,
+	// lastErr - её ошибка, nil при успехе
+	BackoffInterval(attempt int64, lastErr error) time.Duration
+}
+
+type TaskWithSchedule interface {
+	Task
+
+	// время следующего запуска после now, используется вместо RepeatInterval и TaskWithBackoff,
+	// при false задача удаляется
+	NextRunAt(now time.Time) (time.Time, bool)
+}
+
+type TaskWithGate interface {
+	Task
+
+	// проверяется перед запуском, при false запуск пропускается без занятия воркера до следующего интервала повторения
+	Gate() bool
+}
+
+type TaskWithTenant interface {
+	Task
+
+	// арендатор, между арендаторами воркеры распределяются пропорционально их весам
+	Tenant() string
+}
+
+type TaskWithWorkerWaitTimeout interface {
+	Task
+
+	// сколько готовая к запуску задача может ждать свободного воркера, после чего завершается со статусом NoWorker
+	WorkerWaitTimeout() time.Duration
+}
+
+type TaskWithKind interface {
+	Task
+
+	// вид задачи, она передаётся только воркерам, у которых этот вид есть в WorkerWithKinds,
+	// пока такого воркера нет, задача остаётся в очереди, пустой вид принимается любым воркером
+	Kind() string
+}
+
+type TaskWithCache interface {
+	Task
+
+	// успешный результат сохраняется по ключу на CacheTTL, задача с тем же ключом в течение этого времени
+	// получает сохранённый результат без запуска на воркере, пустой ключ или нулевое время отключают кэширование
+	CacheKey() string
+	CacheTTL() time.Duration
+}
+
+type TaskWithStartDelay interface {
+	Task
+
+	// задержка первого запуска относительно момента добавления в диспетчер, в отличие от StartedAt
+	// отсчитывается при каждом добавлении, нулевое значение запускает задачу сразу
+	StartDelay() time.Duration
+}
+
+type TaskWithTags interface {
+	Task
+
+	// произвольные метки, по которым задачи можно искать и отменять через диспетчер
+	Tags() map[string]string
+}
@@ -6,7 +6,7 @@ import (
 	"time"
 	"unsafe"
 
-	"github.com/pborman/uuid"
+	"github.com/mrsmtvd/go-workers"
 )
 
 type BaseTask struct {
@@ -14,14 +14,24 @@ type BaseTask struct {
 	repeats        int64
 	repeatInterval int64
 	timeout        int64
+	hardTimeout    int64
+	workerWait     int64
+	cacheTTL       int64
+	startDelay     int64
 	id             string
 	name           atomic.Value
+	gate           atomic.Value
+	tenant         atomic.Value
+	kind           atomic.Value
+	cacheKey       atomic.Value
+	tags           atomic.Value
 	createdAt      time.Time
 	startedAt      unsafe.Pointer
+	deadline       unsafe.Pointer
 }
 
 func (t *BaseTask) Init() {
-	t.id = uuid.New()
+	t.id = workers.NewId()
 	t.repeats = 1
 	t.createdAt = time.Now()
 }
@@ -44,6 +54,34 @@ func (t *BaseTask) SetName(name string) {
 	t.name.Store(name)
 }
 
+func (t *BaseTask) Tenant() string {
+	var tenant string
+
+	if value := t.tenant.Load(); value != nil {
+		tenant = value.(string)
+	}
+
+	return tenant
+}
+
+func (t *BaseTask) SetTenant(tenant string) {
+	t.tenant.Store(tenant)
+}
+
+func (t *BaseTask) Kind() string {
+	var kind string
+
+	if value := t.kind.Load(); value != nil {
+		kind = value.(string)
+	}
+
+	return kind
+}
+
+func (t *BaseTask) SetKind(kind string) {
+	t.kind.Store(kind)
+}
+
 func (t *BaseTask) Priority() int64 {
 	return atomic.LoadInt64(&t.priority)
 }
@@ -76,6 +114,81 @@ func (t *BaseTask) SetTimeout(duration time.Duration) {
 	atomic.StoreInt64(&t.timeout, int64(duration))
 }
 
+func (t *BaseTask) HardTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.hardTimeout))
+}
+
+func (t *BaseTask) SetHardTimeout(duration time.Duration) {
+	atomic.StoreInt64(&t.hardTimeout, int64(duration))
+}
+
+func (t *BaseTask) WorkerWaitTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.workerWait))
+}
+
+func (t *BaseTask) SetWorkerWaitTimeout(duration time.Duration) {
+	atomic.StoreInt64(&t.workerWait, int64(duration))
+}
+
+func (t *BaseTask) StartDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.startDelay))
+}
+
+func (t *BaseTask) SetStartDelay(delay time.Duration) {
+	atomic.StoreInt64(&t.startDelay, int64(delay))
+}
+
+func (t *BaseTask) Tags() map[string]string {
+	if value := t.tags.Load(); value != nil {
+		return value.(map[string]string)
+	}
+
+	return nil
+}
+
+// метки копируются, поэтому последующие изменения переданной карты на задачу не влияют
+func (t *BaseTask) SetTags(tags map[string]string) {
+	tmp := make(map[string]string, len(tags))
+	for key, value := range tags {
+		tmp[key] = value
+	}
+
+	t.tags.Store(tmp)
+}
+
+func (t *BaseTask) CacheKey() string {
+	var key string
+
+	if value := t.cacheKey.Load(); value != nil {
+		key = value.(string)
+	}
+
+	return key
+}
+
+func (t *BaseTask) CacheTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.cacheTTL))
+}
+
+func (t *BaseTask) SetCache(key string, ttl time.Duration) {
+	t.cacheKey.Store(key)
+	atomic.StoreInt64(&t.cacheTTL, int64(ttl))
+}
+
+func (t *BaseTask) Gate() bool {
+	if value := t.gate.Load(); value != nil {
+		if gate := value.(func() bool); gate != nil {
+			return gate()
+		}
+	}
+
+	return true
+}
+
+func (t *BaseTask) SetGate(gate func() bool) {
+	t.gate.Store(gate)
+}
+
 func (t *BaseTask) CreatedAt() time.Time {
 	return t.createdAt
 }
@@ -89,6 +202,18 @@ func (t *BaseTask) SetStartedAt(startedAt time.Time) {
 	atomic.StorePointer(&t.startedAt, unsafe.Pointer(&startedAt))
 }
 
+func (t *BaseTask) Deadline() time.Time {
+	if p := atomic.LoadPointer(&t.deadline); p != nil {
+		return *(*time.Time)(p)
+	}
+
+	return time.Time{}
+}
+
+func (t *BaseTask) SetDeadline(deadline time.Time) {
+	atomic.StorePointer(&t.deadline, unsafe.Pointer(&deadline))
+}
+
 func (t *BaseTask) String() string {
 	return "Task #" + t.Id()
 }
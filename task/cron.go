@@ -0,0 +1,273 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// дальше этого горизонта расписание, которое ни разу не срабатывает (например, 30 февраля), не ищется
+const cronSearchYears = 5
+
+var (
+	cronMonthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+
+	cronDayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// расписание в стандартном формате cron из 5 полей (минута, час, день месяца, месяц, день недели),
+// а также дескрипторы вида @daily и @every <duration>
+type CronSchedule struct {
+	every time.Duration
+
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse cron spec %s: %s", spec, err.Error())
+		}
+
+		if every <= 0 {
+			return nil, fmt.Errorf("Failed to parse cron spec %s: interval must be positive", spec)
+		}
+
+		return &CronSchedule{every: every}, nil
+	}
+
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Failed to parse cron spec %s: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &CronSchedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+
+	var err error
+
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Failed to parse minute of cron spec %s: %s", spec, err.Error())
+	}
+
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Failed to parse hour of cron spec %s: %s", spec, err.Error())
+	}
+
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Failed to parse day of month of cron spec %s: %s", spec, err.Error())
+	}
+
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("Failed to parse month of cron spec %s: %s", spec, err.Error())
+	}
+
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("Failed to parse day of week of cron spec %s: %s", spec, err.Error())
+	}
+
+	// воскресенье допускается записывать и как 0, и как 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		rangePart := part
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+
+			rangePart = part[:i]
+		}
+
+		var lo, hi int
+
+		switch {
+		case rangePart == "*":
+			lo, hi = min, max
+
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+
+			var err error
+			if lo, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+
+			if hi, err = parseCronValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+
+		default:
+			value, err := parseCronValue(rangePart, names)
+			if err != nil {
+				return 0, err
+			}
+
+			lo, hi = value, value
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", value)
+	}
+
+	return v, nil
+}
+
+// ближайшее время срабатывания строго после t в часовом поясе t. Расписание работает по настенным часам:
+// время, попавшее в переход на летнее время, пропускается, а повторяющийся при переходе на зимнее час
+// срабатывает один раз
+func (s *CronSchedule) Next(t time.Time) (time.Time, bool) {
+	if s.every > 0 {
+		return t.Add(s.every), true
+	}
+
+	loc := t.Location()
+	c := t.Truncate(time.Minute).Add(time.Minute)
+	limit := c.AddDate(cronSearchYears, 0, 0)
+
+	for c.Before(limit) {
+		switch {
+		case s.month&(1<<uint(c.Month())) == 0:
+			c = cronAdvance(c, time.Date(c.Year(), c.Month()+1, 1, 0, 0, 0, 0, loc))
+
+		case !s.dayMatches(c):
+			c = cronAdvance(c, time.Date(c.Year(), c.Month(), c.Day()+1, 0, 0, 0, 0, loc))
+
+		case s.hour&(1<<uint(c.Hour())) == 0:
+			c = cronNextHour(c)
+
+		case s.minute&(1<<uint(c.Minute())) == 0:
+			c = c.Add(time.Minute)
+
+		// time.Date для повторяющегося часа возвращает первое вхождение, второе пропускаем
+		case !time.Date(c.Year(), c.Month(), c.Day(), c.Hour(), c.Minute(), 0, 0, loc).Equal(c):
+			c = c.Add(time.Minute)
+
+		default:
+			return c, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// time.Date переводит несуществующее при переходе на летнее время значение назад,
+// поэтому в таком случае двигаемся по абсолютному времени
+func cronAdvance(current, next time.Time) time.Time {
+	if next.After(current) {
+		return next
+	}
+
+	return cronNextHour(current)
+}
+
+func cronNextHour(t time.Time) time.Time {
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+// если ограничены и день месяца, и день недели, достаточно совпадения любого из них, как в классическом cron
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// задача, запускаемая по расписанию cron, первый запуск откладывается до ближайшего срабатывания
+type CronTask struct {
+	FunctionTask
+
+	schedule *CronSchedule
+}
+
+func NewCronTask(spec string, function func(context.Context) (interface{}, error)) (*CronTask, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &CronTask{
+		schedule: schedule,
+	}
+	t.function = function
+	t.BaseTask.Init()
+	t.SetRepeats(-1)
+
+	if next, ok := schedule.Next(time.Now()); ok {
+		t.SetStartedAt(next)
+	}
+
+	return t, nil
+}
+
+func (t *CronTask) NextRunAt(now time.Time) (time.Time, bool) {
+	return t.schedule.Next(now)
+}
@@ -0,0 +1,91 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadNewYork(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("Timezone database isn't available")
+	}
+
+	return loc
+}
+
+func TestCronNextSkipsSpringForward(t *testing.T) {
+	loc := loadNewYork(t)
+
+	s, err := ParseCron("30 2 * * *")
+	assert.NoError(t, err)
+
+	// 14 марта 2021 года в 02:00 часы переводятся на 03:00, поэтому 02:30 в этот день не существует
+	next, ok := s.Next(time.Date(2021, time.March, 13, 3, 0, 0, 0, loc))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, time.March, 15, 2, 30, 0, 0, loc), next)
+}
+
+func TestCronNextRunsOnceOnFallBack(t *testing.T) {
+	loc := loadNewYork(t)
+
+	s, err := ParseCron("30 1 * * *")
+	assert.NoError(t, err)
+
+	// 7 ноября 2021 года час 01:00-02:00 повторяется, второго запуска в 01:30 EST быть не должно
+	first := time.Date(2021, time.November, 7, 1, 30, 0, 0, loc)
+	_, offset := first.Zone()
+	assert.Equal(t, -4*60*60, offset)
+
+	next, ok := s.Next(first)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, time.November, 8, 1, 30, 0, 0, loc), next)
+}
+
+func TestCronNextEvery(t *testing.T) {
+	s, err := ParseCron("@every 30s")
+	assert.NoError(t, err)
+
+	now := time.Date(2021, time.January, 1, 0, 0, 10, 0, time.UTC)
+	next, ok := s.Next(now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Second*30), next)
+}
+
+func TestCronNextFields(t *testing.T) {
+	now := time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC) // пятница
+
+	cases := map[string]time.Time{
+		"*/15 * * * *":   time.Date(2021, time.January, 1, 12, 15, 0, 0, time.UTC),
+		"0 9-17 * * 1-5": time.Date(2021, time.January, 1, 13, 0, 0, 0, time.UTC),
+		"0 0 * * sun":    time.Date(2021, time.January, 3, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":      time.Date(2021, time.January, 3, 0, 0, 0, 0, time.UTC),
+		"0 0 29 feb *":   time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 15 * 1":     time.Date(2021, time.January, 4, 0, 0, 0, 0, time.UTC),
+		"@monthly":       time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for spec, expected := range cases {
+		s, err := ParseCron(spec)
+		if assert.NoError(t, err, spec) {
+			next, ok := s.Next(now)
+			assert.True(t, ok, spec)
+			assert.Equal(t, expected, next, spec)
+		}
+	}
+
+	s, err := ParseCron("0 0 30 2 *")
+	assert.NoError(t, err)
+
+	_, ok := s.Next(now)
+	assert.False(t, ok)
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every", "@every -1s", "@every foo"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
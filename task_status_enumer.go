@@ -7,9 +7,9 @@ import (
 	"fmt"
 )
 
-const _TaskStatusName = "UndefinedWaitProcessSuccessFailRepeatWaitCancel"
+const _TaskStatusName = "UndefinedWaitProcessSuccessFailRepeatWaitCancelNoWorkerFailByTimeout"
 
-var _TaskStatusIndex = [...]uint8{0, 9, 13, 20, 27, 31, 41, 47}
+var _TaskStatusIndex = [...]uint8{0, 9, 13, 20, 27, 31, 41, 47, 55, 68}
 
 func (i TaskStatus) String() string {
 	if i < 0 || i >= TaskStatus(len(_TaskStatusIndex)-1) {
@@ -18,7 +18,7 @@ func (i TaskStatus) String() string {
 	return _TaskStatusName[_TaskStatusIndex[i]:_TaskStatusIndex[i+1]]
 }
 
-var _TaskStatusValues = []TaskStatus{0, 1, 2, 3, 4, 5, 6}
+var _TaskStatusValues = []TaskStatus{0, 1, 2, 3, 4, 5, 6, 7, 8}
 
 var _TaskStatusNameToValueMap = map[string]TaskStatus{
 	_TaskStatusName[0:9]:   0,
@@ -28,6 +28,8 @@ var _TaskStatusNameToValueMap = map[string]TaskStatus{
 	_TaskStatusName[27:31]: 4,
 	_TaskStatusName[31:41]: 5,
 	_TaskStatusName[41:47]: 6,
+	_TaskStatusName[47:55]: 7,
+	_TaskStatusName[55:68]: 8,
 }
 
 // TaskStatusString retrieves an enum value from the enum constants string name.
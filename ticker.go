@@ -22,13 +22,20 @@ type Ticker struct {
 }
 
 func NewTicker(d time.Duration) *Ticker {
-	c := clock.NewClock()
+	return NewTickerWithClock(clock.NewClock(), d)
+}
+
+// при d <= 0 тикер создаётся остановленным и не срабатывает, пока не задан положительный интервал
+func NewTickerWithClock(c clock.Clock, d time.Duration) *Ticker {
 	t := &Ticker{
 		c:      make(chan time.Time, 1),
 		change: make(chan time.Duration, 1),
 		stop:   make(chan struct{}, 1),
 		clock:  c,
-		ticker: c.NewTicker(d),
+	}
+
+	if d > 0 {
+		t.ticker = c.NewTicker(d)
 	}
 
 	return t
@@ -38,31 +45,61 @@ func (t *Ticker) run() {
 	atomic.StoreUint32(&t.started, 1)
 
 	for {
+		// у отключённого тикера канал nil, и срабатываний нет
+		var ticks <-chan time.Time
+		if t.ticker != nil {
+			ticks = t.ticker.C()
+		}
+
 		select {
 		case <-t.stop:
 			if t.IsStart() {
-				t.ticker.Stop()
+				t.stopTicker()
 				atomic.StoreUint32(&t.started, 0)
 			}
 
 			return
 
-		case c := <-t.ticker.C():
+		case c := <-ticks:
 			t.c <- c
 
 		case d := <-t.change:
-			t.ticker = t.clock.NewTicker(d)
+			t.stopTicker()
+
+			if d > 0 {
+				t.ticker = t.clock.NewTicker(d)
+			}
 		}
 	}
 }
 
+func (t *Ticker) stopTicker() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		t.ticker = nil
+	}
+}
+
 func (t *Ticker) C() <-chan time.Time {
 	t.Start()
 	return t.c
 }
 
+// меняет интервал, d <= 0 отключает срабатывания, не блокируется: из нескольких ещё не применённых
+// изменений действует последнее
 func (t *Ticker) SetDuration(d time.Duration) {
-	t.change <- d
+	for {
+		select {
+		case t.change <- d:
+			return
+		default:
+		}
+
+		select {
+		case <-t.change:
+		default:
+		}
+	}
 }
 
 func (t *Ticker) IsStart() bool {
@@ -27,3 +27,40 @@ type Worker interface {
 	Id() string
 	CreatedAt() time.Time
 }
+
+// функция выполнения задачи с той же сигнатурой, что и Worker.RunTask
+type RunFunc func(context.Context, Task) (interface{}, error)
+
+// воркер, который может отказаться от задачи, например, если его внутренняя очередь заполнена,
+// отклонённая задача передаётся другому воркеру, а если таких нет, остаётся в очереди до следующей попытки
+type WorkerWithAccept interface {
+	Worker
+
+	CanAccept(Task) bool
+}
+
+// воркер, выполняющий только задачи перечисленных видов, задачи без вида он принимает любые,
+// а воркер без этого интерфейса принимает задачи любого вида
+type WorkerWithKinds interface {
+	Worker
+
+	Kinds() []string
+}
+
+// воркер, способный выполнять несколько задач одновременно, например, поверх пула соединений,
+// остаётся доступным для новых задач, пока их количество меньше Capacity
+type WorkerWithCapacity interface {
+	Worker
+
+	Capacity() int
+}
+
+// воркер с собственными ресурсами, например, соединениями с базой: OnStart вызывается при добавлении
+// и ошибка отменяет добавление, OnStop вызывается после удаления воркера или остановки диспетчера,
+// когда завершились все его задачи, отменённые задачи без жёсткого таймаута при этом не ожидаются
+type WorkerWithLifecycle interface {
+	Worker
+
+	OnStart(context.Context) error
+	OnStop(context.Context)
+}
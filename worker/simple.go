@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/mrsmtvd/go-workers"
-	"github.com/pborman/uuid"
 )
 
 type SimpleWorker struct {
@@ -16,7 +15,7 @@ type SimpleWorker struct {
 
 func NewSimpleWorker() *SimpleWorker {
 	return &SimpleWorker{
-		id:        uuid.New(),
+		id:        workers.NewId(),
 		createdAt: time.Now(),
 	}
 }